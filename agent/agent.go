@@ -0,0 +1,304 @@
+// Package agent implements the sherlock agent. Similar to the ssh-agent it
+// holds unlocked group keys in memory and hands them out to sherlock
+// processes of the same user through a unix socket. The keys are opaque to
+// the agent and stored under a name chosen by the client. sherlock hands it
+// vault keys derived from the group passwords (see security.DeriveVaultKey),
+// never the passwords themselves
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	socketName = "agent.sock"
+	dirPerm    = 0700
+	socketPerm = 0600
+	// connTimeout limits how long a single client
+	// connection can be kept open
+	connTimeout = 5 * time.Second
+	// sweepInterval is the interval in which expired
+	// keys are removed from memory
+	sweepInterval = 10 * time.Second
+)
+
+const (
+	opGet       = "get"
+	opAdd       = "add"
+	opRemove    = "remove"
+	opRemoveAll = "remove-all"
+	opStop      = "stop"
+)
+
+var (
+	ErrNotRunning       = fmt.Errorf("sherlock agent is not running")
+	ErrRunning          = fmt.Errorf("sherlock agent is already running")
+	ErrInsecureSocket   = fmt.Errorf("agent socket directory is accessible by or owned by another user")
+	ErrPeerRejected     = fmt.Errorf("connection from another user rejected")
+	ErrUntrustedAgent   = fmt.Errorf("agent socket is served by another user")
+	ErrUnknownOperation = fmt.Errorf("unknown agent operation")
+)
+
+// request is sent by the client as a single json line
+type request struct {
+	Op    string `json:"op"`
+	Group string `json:"group,omitempty"`
+	Key   string `json:"key,omitempty"`
+}
+
+// response answers a request as a single json line
+type response struct {
+	Key   string `json:"key,omitempty"`
+	Found bool   `json:"found,omitempty"`
+	Count int    `json:"count,omitempty"`
+	Err   string `json:"error,omitempty"`
+}
+
+// entry is an unlocked group key
+type entry struct {
+	key      []byte
+	unlocked time.Time
+	used     time.Time
+}
+
+// Agent holds unlocked group keys. A key is dropped once it has not been
+// used for the idle timeout or once it is older than the max lifetime
+type Agent struct {
+	mu          sync.Mutex
+	keys        map[string]*entry
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+	now         func() time.Time
+	stop        context.CancelFunc
+}
+
+func New(idleTimeout, maxLifetime time.Duration) *Agent {
+	return &Agent{
+		keys:        make(map[string]*entry),
+		idleTimeout: idleTimeout,
+		maxLifetime: maxLifetime,
+		now:         time.Now,
+	}
+}
+
+// SocketPath returns the per-user socket of the agent
+// => $XDG_RUNTIME_DIR/sherlock/agent.sock or $TMPDIR/sherlock-<uid>/agent.sock
+func SocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "sherlock", socketName)
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("sherlock-%d", os.Getuid()), socketName)
+}
+
+// Listen creates the agent socket only accessible by the current user.
+// A stale socket of an agent which is no longer running is replaced
+func Listen(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return nil, err
+	}
+	if err := checkSocketDir(dir, os.Getuid()); err != nil {
+		return nil, err
+	}
+	if NewClient(path).Running() {
+		return nil, ErrRunning
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketPerm); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// checkSocketDir makes sure the socket directory is a directory owned by
+// the uid and not accessible by others. Another user could otherwise create
+// the directory in a shared location like /tmp first and serve a fake agent
+func checkSocketDir(dir string, uid int) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	owner, ok := fileOwner(info)
+	if !info.IsDir() || info.Mode().Perm()&0077 != 0 || !ok || owner != uid {
+		return fmt.Errorf("%w: %s", ErrInsecureSocket, dir)
+	}
+	return nil
+}
+
+// Serve handles client connections until the context is done or a client
+// stops the agent. All keys are wiped from memory once Serve returns
+func (a *Agent) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	a.mu.Lock()
+	a.stop = cancel
+	a.mu.Unlock()
+	defer a.removeAll()
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	go a.sweep(ctx)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go a.handle(conn)
+	}
+}
+
+// handle answers the requests of a client. Connections of
+// processes of other users are closed right away
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+
+	uid, err := peerUID(conn)
+	if err != nil || uid != os.Getuid() {
+		_ = json.NewEncoder(conn).Encode(response{Err: ErrPeerRejected.Error()})
+		return
+	}
+	_ = conn.SetDeadline(time.Now().Add(connTimeout))
+
+	dec, enc := json.NewDecoder(conn), json.NewEncoder(conn)
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if err := enc.Encode(a.do(req)); err != nil {
+			return
+		}
+	}
+}
+
+func (a *Agent) do(req request) response {
+	switch req.Op {
+	case opGet:
+		key, ok := a.get(req.Group)
+		return response{Key: key, Found: ok}
+	case opAdd:
+		a.add(req.Group, req.Key)
+		return response{}
+	case opRemove:
+		return response{Found: a.remove(req.Group)}
+	case opRemoveAll:
+		return response{Count: a.removeAll()}
+	case opStop:
+		a.mu.Lock()
+		if a.stop != nil {
+			a.stop()
+		}
+		a.mu.Unlock()
+		return response{}
+	}
+	return response{Err: fmt.Sprintf("%v: %q", ErrUnknownOperation, req.Op)}
+}
+
+func (a *Agent) add(gid, key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if e, ok := a.keys[gid]; ok {
+		wipe(e.key)
+	}
+	now := a.now()
+	a.keys[gid] = &entry{key: []byte(key), unlocked: now, used: now}
+}
+
+// get returns the key of the group if it has not expired yet.
+// Every lookup resets the idle timeout of the key
+func (a *Agent) get(gid string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e, ok := a.keys[gid]
+	if !ok {
+		return "", false
+	}
+	now := a.now()
+	if a.expired(e, now) {
+		wipe(e.key)
+		delete(a.keys, gid)
+		return "", false
+	}
+	e.used = now
+	return string(e.key), true
+}
+
+func (a *Agent) remove(gid string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e, ok := a.keys[gid]
+	if !ok {
+		return false
+	}
+	wipe(e.key)
+	delete(a.keys, gid)
+	return true
+}
+
+func (a *Agent) removeAll() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n := len(a.keys)
+	for gid, e := range a.keys {
+		wipe(e.key)
+		delete(a.keys, gid)
+	}
+	return n
+}
+
+func (a *Agent) expired(e *entry, now time.Time) bool {
+	return now.Sub(e.used) > a.idleTimeout || now.Sub(e.unlocked) > a.maxLifetime
+}
+
+// sweep removes expired keys from memory
+func (a *Agent) sweep(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		a.mu.Lock()
+		now := a.now()
+		for gid, e := range a.keys {
+			if a.expired(e, now) {
+				wipe(e.key)
+				delete(a.keys, gid)
+			}
+		}
+		a.mu.Unlock()
+	}
+}
+
+// wipe overwrites a key before it is released
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAgentExpiration(t *testing.T) {
+	start := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	tt := []struct {
+		name string
+		// uses are the offsets from start at which the key is requested
+		uses  []time.Duration
+		found bool
+	}{
+		{name: "fresh key", uses: []time.Duration{time.Minute}, found: true},
+		{name: "idle timeout", uses: []time.Duration{16 * time.Minute}, found: false},
+		{name: "used within idle timeout", uses: []time.Duration{10 * time.Minute, 20 * time.Minute, 30 * time.Minute}, found: true},
+		{name: "max lifetime", uses: []time.Duration{10 * time.Minute, 20 * time.Minute, 30 * time.Minute, 40 * time.Minute, 50 * time.Minute, 61 * time.Minute}, found: false},
+	}
+	for _, tc := range tt {
+		now := start
+		a := New(15*time.Minute, time.Hour)
+		a.now = func() time.Time { return now }
+		a.add("default", "group-key")
+
+		var found bool
+		for _, use := range tc.uses {
+			now = start.Add(use)
+			_, found = a.get("default")
+		}
+		if found != tc.found {
+			t.Fatalf("Agent.get (%s): want: %v, have: %v", tc.name, tc.found, found)
+		}
+	}
+}
+
+func TestAgentRemove(t *testing.T) {
+	a := New(time.Hour, time.Hour)
+	a.add("default", "default-key")
+	a.add("prod", "prod-key")
+
+	if ok := a.remove("default"); !ok {
+		t.Fatalf("Agent.remove: want: true, have: %v", ok)
+	}
+	if _, ok := a.get("default"); ok {
+		t.Fatalf("Agent.get: want: removed key not found, have: found")
+	}
+	if n := a.removeAll(); n != 1 {
+		t.Fatalf("Agent.removeAll: want: 1, have: %d", n)
+	}
+}
+
+func TestClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sherlock-agent")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sherlock", socketName)
+
+	// without a running agent keys are not found
+	c := NewClient(path)
+	if _, ok, err := c.Key("default"); ok || err != nil {
+		t.Fatalf("Client.Key: want: not found, have: ok=%v, err=%v", ok, err)
+	}
+	if err := c.Add("default", "group-key"); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("Client.Add: want: %v, have: %v", ErrNotRunning, err)
+	}
+
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatalf("agent.Listen: want: nil, have: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != socketPerm {
+		t.Fatalf("agent.Listen: want: %04o, have: %04o", socketPerm, perm)
+	}
+	if _, err := Listen(path); err != ErrRunning {
+		t.Fatalf("agent.Listen: want: %v, have: %v", ErrRunning, err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- New(time.Hour, time.Hour).Serve(context.Background(), l)
+	}()
+
+	if err := c.Add("default", "group-key"); err != nil {
+		t.Fatalf("Client.Add: want: nil, have: %v", err)
+	}
+	key, ok, err := c.Key("default")
+	if err != nil || !ok || key != "group-key" {
+		t.Fatalf("Client.Key: want: group-key, have: %q (ok=%v, err=%v)", key, ok, err)
+	}
+	if ok, err := c.Remove("default"); err != nil || !ok {
+		t.Fatalf("Client.Remove: want: true, have: %v (%v)", ok, err)
+	}
+	if _, ok, _ := c.Key("default"); ok {
+		t.Fatalf("Client.Key: want: not found after remove, have: found")
+	}
+
+	if err := c.Stop(); err != nil {
+		t.Fatalf("Client.Stop: want: nil, have: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Agent.Serve: want: nil, have: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Agent.Serve: agent did not stop")
+	}
+	if c.Running() {
+		t.Fatalf("Client.Running: want: false after stop, have: true")
+	}
+}
+
+func TestListenInsecureDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sherlock-agent")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatalf("os.Chmod: %v", err)
+	}
+
+	if _, err := Listen(filepath.Join(dir, socketName)); !errors.Is(err, ErrInsecureSocket) {
+		t.Fatalf("agent.Listen: want: %v, have: %v", ErrInsecureSocket, err)
+	}
+}
+
+func TestCheckSocketDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sherlock-agent")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := checkSocketDir(dir, os.Getuid()); err != nil {
+		t.Fatalf("agent.checkSocketDir: want: nil, have: %v", err)
+	}
+	// a directory created by another user is never trusted
+	if err := checkSocketDir(dir, os.Getuid()+1); !errors.Is(err, ErrInsecureSocket) {
+		t.Fatalf("agent.checkSocketDir: want: %v, have: %v", ErrInsecureSocket, err)
+	}
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// dialTimeout is the time to wait for the agent to accept a connection
+const dialTimeout = time.Second
+
+// Client talks to the agent listening on the socket. It implements
+// keys.Provider so the agent can be asked for a group key before prompting
+type Client struct {
+	path string
+}
+
+func NewClient(path string) *Client {
+	return &Client{path: path}
+}
+
+// Path returns the socket the client connects to
+func (c *Client) Path() string {
+	return c.path
+}
+
+// Running reports whether an agent accepts connections on the socket
+func (c *Client) Running() bool {
+	conn, err := net.DialTimeout("unix", c.path, dialTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Key returns the unlocked key of the group. If the agent is
+// not running the key is reported as not found
+func (c *Client) Key(gid string) (string, bool, error) {
+	resp, err := c.do(request{Op: opGet, Group: gid})
+	if err != nil {
+		if errors.Is(err, ErrNotRunning) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return resp.Key, resp.Found, nil
+}
+
+// Add hands the key of the group to the agent
+func (c *Client) Add(gid, key string) error {
+	_, err := c.do(request{Op: opAdd, Group: gid, Key: key})
+	return err
+}
+
+// Remove drops the key of the group from the agent. It reports
+// whether the group was unlocked
+func (c *Client) Remove(gid string) (bool, error) {
+	resp, err := c.do(request{Op: opRemove, Group: gid})
+	if err != nil {
+		return false, err
+	}
+	return resp.Found, nil
+}
+
+// RemoveAll drops all keys from the agent and returns
+// the number of groups which were unlocked
+func (c *Client) RemoveAll() (int, error) {
+	resp, err := c.do(request{Op: opRemoveAll})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+// Stop wipes all keys and shuts the agent down
+func (c *Client) Stop() error {
+	_, err := c.do(request{Op: opStop})
+	return err
+}
+
+func (c *Client) do(req request) (response, error) {
+	conn, err := net.DialTimeout("unix", c.path, dialTimeout)
+	if err != nil {
+		return response{}, fmt.Errorf("%w: %v", ErrNotRunning, err)
+	}
+	defer conn.Close()
+	// never hand keys to an agent run by another user
+	if uid, err := peerUID(conn); err != nil || uid != os.Getuid() {
+		return response{}, ErrUntrustedAgent
+	}
+	_ = conn.SetDeadline(time.Now().Add(connTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return response{}, err
+	}
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return response{}, err
+	}
+	if resp.Err != "" {
+		return response{}, errors.New(resp.Err)
+	}
+	return resp, nil
+}
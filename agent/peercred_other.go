@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package agent
+
+import (
+	"fmt"
+	"net"
+)
+
+// peerUID is not supported on this platform. The agent
+// rejects all connections
+func peerUID(conn net.Conn) (int, error) {
+	return -1, fmt.Errorf("peer credentials not supported")
+}
@@ -2,136 +2,198 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/KonstantinGasser/sherlock/internal"
 	"github.com/KonstantinGasser/sherlock/security"
 	"github.com/KonstantinGasser/sherlock/terminal"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
-func cmdAdd(ctx context.Context, sherlock *internal.Sherlock) *cobra.Command {
+func cmdAdd(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys, cfg *viper.Viper) *cobra.Command {
 	add := &cobra.Command{
 		Use:   "add",
 		Short: "add an group or account to sherlock",
 		Long:  "add either a new group to sherlock or an account to a sherlock-group",
-		Run: func(cmd *cobra.Command, args []string) {
-			_ = cmd.Help()
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
 		},
 	}
-	add.AddCommand(cmdAddGroup(ctx, sherlock))
-	add.AddCommand(cmdAddAccount(ctx, sherlock))
+	add.AddCommand(cmdAddGroup(ctx, sherlock, keyring))
+	add.AddCommand(cmdAddAccount(ctx, sherlock, keyring, cfg))
 
 	return add
 }
 
 type addGroupOptions struct {
-	insecure bool
+	insecure   bool
+	suggest    bool
+	noConfirm  bool
+	minEntropy float64
+	passphraseOptions
 }
 
-func cmdAddGroup(ctx context.Context, sherlock *internal.Sherlock) *cobra.Command {
+func cmdAddGroup(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
 	var opts addGroupOptions
 	addGroup := &cobra.Command{
 		Use:   "group",
 		Short: "add a group to sherlock",
 		Long:  "add a new group for accounts to sherlock",
 		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) <= 0 {
-				terminal.Error("group name not set (sherlock add group [group-name])")
-				return
+				return fmt.Errorf("group name not set (sherlock add group [group-name])")
 			}
-			groupKey, err := terminal.ReadPassword("(%s) new password: ", args[0])
-			if err != nil {
-				terminal.Error(err.Error())
-				return
+			var groupKey string
+			var err error
+			if opts.suggest {
+				groupKey, err = suggestPassphrase(opts.passphraseOptions)
+				if err != nil {
+					return err
+				}
+			}
+			minEntropy := opts.minEntropy
+			if minEntropy == 0 {
+				minEntropy = security.DefaultMinEntropy
 			}
-			if err := sherlock.SetupGroup(args[0], groupKey, opts.insecure); err != nil {
-				terminal.Error(err.Error())
-				return
+			if groupKey == "" {
+				groupKey, err = keyring.readNew(args[0], "new password", minEntropy, opts.noConfirm)
+				if err != nil {
+					return err
+				}
+			}
+			if err := sherlock.SetupGroup(args[0], groupKey, opts.insecure, internal.OptMinEntropy(opts.minEntropy)); err != nil {
+				return err
 			}
 			terminal.Success("group %q added to sherlock", args[0])
+			return nil
 		},
 	}
 	addGroup.Flags().BoolVarP(&opts.insecure, "insecure", "i", false, "allow insecure group password")
+	addGroup.Flags().BoolVarP(&opts.suggest, "suggest", "s", false, "suggest a passphrase as group password")
+	addGroup.Flags().BoolVar(&opts.noConfirm, "no-confirm", false, "do not ask to repeat the group password")
+	addGroup.Flags().Float64Var(&opts.minEntropy, "min-entropy", 0, "minimum entropy in bits of the group and account passwords (default 60)")
+	bindPassphraseFlags(addGroup.Flags(), &opts.passphraseOptions)
 
 	return addGroup
 }
 
 type addAccountOptions struct {
-	tag      string
-	insecure bool
-	generate bool
-	length   int
+	tag          string
+	insecure     bool
+	generate     bool
+	noConfirm    bool
+	fields       []string
+	secretFields []string
+	generatorOptions
 }
 
-func cmdAddAccount(ctx context.Context, sherlock *internal.Sherlock) *cobra.Command {
+func cmdAddAccount(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys, cfg *viper.Viper) *cobra.Command {
 	var opts addAccountOptions
 	addGroup := &cobra.Command{
 		Use:   "account",
 		Short: "add an account to a sherlock group",
 		Long:  "add a new account to a sherlock group",
 		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 
 			// check if the group exists
 			gid, _, err := internal.SplitQuery(args[0])
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 			if err := sherlock.GroupExists(gid); err == nil {
-				terminal.Error("group does not exist")
-				return
+				return fmt.Errorf("group does not exist")
 			}
 
-			groupKey, err := terminal.ReadPassword("(%s) password: ", gid)
+			groupKey, err := keyring.read(gid, "password")
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 
 			// validate the password/key
-			err = sherlock.CheckGroupKey(ctx, args[0], groupKey)
+			group, err := sherlock.LoadGroup(gid, groupKey)
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 
 			// figure out password: either auto gen password or read from stdin
 			var password string
-			switch true {
+			switch {
 			case opts.generate:
-				password, err = security.GenPassword(opts.length)
+				password, err = generatePassword(opts.generatorOptions)
 				if err != nil {
-					terminal.Error(err.Error())
-					return
+					return err
 				}
 			default:
-				password, err = terminal.ReadPassword("(%s) new password: ", args[0])
+				password, err = readNewPassword(args[0], "new password", group.RequiredEntropy(), opts.noConfirm)
 				if err != nil {
-					terminal.Error(err.Error())
-					return
+					return err
 				}
+				warnBreached(cfg, password)
 			}
 
 			// create/store new Account
-			account, err := internal.NewAccount(args[0], password, opts.tag, opts.insecure)
+			account, err := internal.NewAccount(args[0], password, opts.tag)
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
+			}
+			if err := setFields(account, args[0], opts.fields, opts.secretFields); err != nil {
+				return err
 			}
-			if err := sherlock.UpdateState(ctx, args[0], groupKey, internal.OptAddAccount(account)); err != nil {
-				terminal.Error(err.Error())
-				return
+			if err := sherlock.UpdateState(ctx, args[0], groupKey, internal.OptAddAccount(account, opts.insecure)); err != nil {
+				return err
 			}
 			terminal.Success("account %q successfully added to %q", account.Name, args[0])
+			return nil
 		},
 	}
 
 	addGroup.Flags().StringVarP(&opts.tag, "tag", "t", "", "optional tag for this account")
 	addGroup.Flags().BoolVarP(&opts.insecure, "insecure", "i", false, "allow insecure group password")
 	addGroup.Flags().BoolVarP(&opts.generate, "gen-password", "p", false, "auto-generate account password")
-	addGroup.Flags().IntVarP(&opts.length, "len", "l", 20, "length for auto-generated password")
+	addGroup.Flags().BoolVar(&opts.noConfirm, "no-confirm", false, "do not ask to repeat the account password")
+	bindGeneratorFlags(addGroup.Flags(), &opts.generatorOptions)
+	addGroup.Flags().StringArrayVarP(&opts.fields, "field", "f", nil, "additional field as key=value (username, url, notes or any custom key)")
+	addGroup.Flags().StringArrayVar(&opts.secretFields, "secret-field", nil, "additional secret field as key=value (prompts for the value if omitted)")
 
 	return addGroup
 }
+
+// fieldSetter is implemented by accounts allowing to set standard
+// and custom fields
+type fieldSetter interface {
+	SetField(key, value string, secret bool) error
+}
+
+// setFields parses the key=value pairs of the --field and --secret-field
+// flags and sets them on the account. Secret fields without a value are
+// read from the terminal
+func setFields(account fieldSetter, query string, fields, secretFields []string) error {
+	for _, pair := range fields {
+		key, value, err := internal.ParseField(pair)
+		if err != nil {
+			return err
+		}
+		if err := account.SetField(key, value, false); err != nil {
+			return err
+		}
+	}
+	for _, pair := range secretFields {
+		key, value, err := internal.ParseField(pair)
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			value, err = terminal.ReadPassword("(%s) %s: ", query, key)
+			if err != nil {
+				return err
+			}
+		}
+		if err := account.SetField(key, value, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
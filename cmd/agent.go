@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/KonstantinGasser/sherlock/agent"
+	"github.com/KonstantinGasser/sherlock/internal"
+	"github.com/KonstantinGasser/sherlock/terminal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// configAgentSocket is the unix socket of the sherlock agent
+	configAgentSocket = "agent.socket"
+	// configAgentIdleTimeout is the time after which an unused
+	// group key is dropped by the agent
+	configAgentIdleTimeout = "agent.idle-timeout"
+	// configAgentMaxLifetime is the time after which a group key is
+	// dropped by the agent even if it is still used
+	configAgentMaxLifetime = "agent.max-lifetime"
+
+	// agentStartTimeout is the time to wait for a detached
+	// agent to accept connections
+	agentStartTimeout = 2 * time.Second
+)
+
+var (
+	ErrAgentNotStarted = fmt.Errorf("sherlock agent did not start")
+)
+
+type agentOptions struct {
+	detach bool
+	stop   bool
+}
+
+func cmdAgent(ctx context.Context, cfg *viper.Viper) *cobra.Command {
+	var opts agentOptions
+	agentCmd := &cobra.Command{
+		Use:   "agent",
+		Short: "keep unlocked group keys in memory",
+		Long:  "the agent keeps group keys unlocked with \"sherlock unlock\" in memory so other commands do not prompt for them. Keys are dropped after the idle timeout or the max lifetime",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			socket := cfg.GetString(configAgentSocket)
+			switch {
+			case opts.stop:
+				if err := agent.NewClient(socket).Stop(); err != nil {
+					return err
+				}
+				terminal.Success("sherlock agent stopped")
+			case opts.detach:
+				pid, err := spawnAgent(socket, cfg)
+				if err != nil {
+					return err
+				}
+				terminal.Success("sherlock agent running on %s (pid %d)", socket, pid)
+			default:
+				if err := runAgent(ctx, socket, cfg); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	agentCmd.Flags().BoolVarP(&opts.detach, "detach", "d", false, "run the agent in the background")
+	agentCmd.Flags().BoolVar(&opts.stop, "stop", false, "wipe all keys and stop the running agent")
+	agentCmd.Flags().Duration("idle-timeout", 15*time.Minute, "drop group keys not used for this long")
+	agentCmd.Flags().Duration("max-lifetime", 4*time.Hour, "drop group keys unlocked this long ago")
+	_ = cfg.BindPFlag(configAgentIdleTimeout, agentCmd.Flags().Lookup("idle-timeout"))
+	_ = cfg.BindPFlag(configAgentMaxLifetime, agentCmd.Flags().Lookup("max-lifetime"))
+
+	return agentCmd
+}
+
+// runAgent serves the agent socket until the process is interrupted
+func runAgent(ctx context.Context, socket string, cfg *viper.Viper) error {
+	l, err := agent.Listen(socket)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	terminal.Info("sherlock agent listening on %s", socket)
+	return agent.New(
+		cfg.GetDuration(configAgentIdleTimeout),
+		cfg.GetDuration(configAgentMaxLifetime),
+	).Serve(ctx, l)
+}
+
+// spawnAgent starts the agent in a detached process and waits
+// until it accepts connections
+func spawnAgent(socket string, cfg *viper.Viper) (int, error) {
+	client := agent.NewClient(socket)
+	if client.Running() {
+		return 0, agent.ErrRunning
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	daemon := exec.Command(exe, "agent",
+		"--idle-timeout", cfg.GetDuration(configAgentIdleTimeout).String(),
+		"--max-lifetime", cfg.GetDuration(configAgentMaxLifetime).String(),
+	)
+	daemon.Env = append(os.Environ(), "SHERLOCK_AGENT_SOCKET="+socket)
+	detach(daemon)
+	if err := daemon.Start(); err != nil {
+		return 0, err
+	}
+	pid := daemon.Process.Pid
+	if err := daemon.Process.Release(); err != nil {
+		return 0, err
+	}
+
+	for deadline := time.Now().Add(agentStartTimeout); time.Now().Before(deadline); {
+		if client.Running() {
+			return pid, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return 0, ErrAgentNotStarted
+}
+
+func cmdUnlock(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
+	return &cobra.Command{
+		Use:   "unlock",
+		Short: "hand a group key to the sherlock agent",
+		Long:  "unlock verifies the group password and hands the key derived from it to the running sherlock agent. Other commands take the key from the agent instead of prompting for the password",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !keyring.agent.Running() {
+				return fmt.Errorf("%v (start it with \"sherlock agent --detach\")", agent.ErrNotRunning)
+			}
+			groupKey, err := keyring.readUncached(args[0], "password")
+			if err != nil {
+				return err
+			}
+			// the agent never holds the group password
+			vaultKey, err := sherlock.VaultKey(args[0], groupKey)
+			if err != nil {
+				return err
+			}
+			if err := keyring.unlock(args[0], vaultKey); err != nil {
+				return err
+			}
+			terminal.Success("group %q unlocked", args[0])
+			return nil
+		},
+	}
+}
+
+type lockOptions struct {
+	all bool
+}
+
+func cmdLock(ctx context.Context, keyring *groupKeys) *cobra.Command {
+	var opts lockOptions
+	lock := &cobra.Command{
+		Use:   "lock",
+		Short: "drop group keys from the sherlock agent",
+		Long:  "lock drops the key of a group (or with --all of every group) from the sherlock agent",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.all {
+				n, err := keyring.agent.RemoveAll()
+				if err != nil {
+					return err
+				}
+				terminal.Success("%d group(s) locked", n)
+				return nil
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("group name not set (sherlock lock [group-name] or sherlock lock --all)")
+			}
+			unlocked, err := keyring.lock(args[0])
+			if err != nil {
+				return err
+			}
+			if !unlocked {
+				terminal.Info("group %q was not unlocked", args[0])
+				return nil
+			}
+			terminal.Success("group %q locked", args[0])
+			return nil
+		},
+	}
+	lock.Flags().BoolVarP(&opts.all, "all", "a", false, "lock all groups")
+
+	return lock
+}
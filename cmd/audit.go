@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/KonstantinGasser/sherlock/hibp"
+	"github.com/KonstantinGasser/sherlock/internal"
+	"github.com/KonstantinGasser/sherlock/terminal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// configHIBPFile is the path of a local Pwned Passwords SHA-1 file.
+	// If set new passwords are checked against it
+	configHIBPFile = "hibp.file"
+	// configAuditMinScore is the score below which audit fails
+	configAuditMinScore = "audit.min-score"
+)
+
+var (
+	ErrBreachedWithoutFile = fmt.Errorf("the breached check requires a Pwned Passwords file (use --breached or hibp.file)")
+	ErrIncompleteAudit     = fmt.Errorf("audit incomplete")
+	ErrNoGroupKey          = fmt.Errorf("no password provided")
+)
+
+type auditOptions struct {
+	breached string
+	only     []string
+	minScore int
+	outputOptions
+}
+
+func cmdAudit(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys, cfg *viper.Viper) *cobra.Command {
+	var opts auditOptions
+	audit := &cobra.Command{
+		Use:   "audit [groups...]",
+		Short: "report the health of the passwords of all (or the given) groups",
+		Long:  "audit unlocks all (or the given) groups and reports weak, expired, never rotated, untagged, reused and breached passwords with a score from 0 to 100. Breached passwords are looked up in a local Pwned Passwords SHA-1 file (ordered by hash) without loading it into memory or using the network. Passwords are never printed. Leave the password empty to skip a group. audit exits with 1 on errors, if a group was skipped or no account was audited and with --min-score (or audit.min-score of the config) if the score is lower",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mode, err := opts.mode()
+			if err != nil {
+				return err
+			}
+			// validate the checks before asking for any password
+			for _, check := range opts.only {
+				if !contains(internal.Checks, check) {
+					return fmt.Errorf("%v: %q", internal.ErrUnknownCheck, check)
+				}
+			}
+			minScore := opts.minScore
+			if !cmd.Flags().Changed("min-score") {
+				minScore = cfg.GetInt(configAuditMinScore)
+			}
+			path := opts.breached
+			if path == "" {
+				path = expandHome(cfg.GetString(configHIBPFile))
+			}
+			reportOpts := internal.ReportOptions{Checks: opts.only}
+			if path != "" {
+				dump, err := hibp.Open(path)
+				if err != nil {
+					return err
+				}
+				defer dump.Close()
+				reportOpts.BreachCount = dump.Count
+			} else if contains(opts.only, internal.CheckBreached) {
+				return ErrBreachedWithoutFile
+			}
+
+			groups := args
+			if len(groups) == 0 {
+				registered, err := sherlock.ReadRegisteredGroups()
+				if err != nil {
+					return err
+				}
+				groups = registered
+			}
+			audit := internal.NewAudit()
+			for _, gid := range groups {
+				if err := addAuditGroup(audit, sherlock, keyring, gid); err != nil {
+					terminal.Warning("skipping group %q: %v", gid, err)
+					audit.Skip(gid, err)
+				}
+			}
+
+			report, err := audit.Report(reportOpts)
+			if err != nil {
+				return err
+			}
+			if mode == outputTable && reportOpts.BreachCount == nil {
+				terminal.Info("breached passwords not checked (use --breached or hibp.file)")
+			}
+			if err := opts.printTo(os.Stdout, mode, reportPrinter(report)); err != nil {
+				return err
+			}
+			// an audit which could not read everything must not pass
+			switch {
+			case len(report.Skipped) > 0:
+				return fmt.Errorf("%v: %d group(s) skipped", ErrIncompleteAudit, len(report.Skipped))
+			case report.Accounts == 0:
+				return fmt.Errorf("%v: no accounts audited", ErrIncompleteAudit)
+			case report.Score < minScore:
+				return fmt.Errorf("audit score %d is below the minimum score of %d", report.Score, minScore)
+			}
+			return nil
+		},
+	}
+	audit.Flags().StringVar(&opts.breached, "breached", "", "check passwords against a local Pwned Passwords SHA-1 file ordered by hash (default hibp.file of the config)")
+	audit.Flags().StringSliceVar(&opts.only, "only", nil, "run only these checks: "+strings.Join(internal.Checks, ", "))
+	audit.Flags().IntVar(&opts.minScore, "min-score", 0, "exit with 1 if the score is below (default audit.min-score of the config)")
+	bindOutputFlags(audit.Flags(), &opts.outputOptions)
+
+	return audit
+}
+
+// addAuditGroup reads the key of a group and adds the unlocked group
+// to the audit. Leaving the password empty skips the group. A key of
+// the agent which no longer unlocks the group is dropped and prompted for
+func addAuditGroup(audit *internal.Audit, sherlock *internal.Sherlock, keyring *groupKeys, gid string) error {
+	groupKey, err := keyring.read(gid, "password")
+	if err != nil {
+		return err
+	}
+	if groupKey == "" {
+		return ErrNoGroupKey
+	}
+	group, err := sherlock.LoadGroup(gid, groupKey)
+	if errors.Is(err, internal.ErrWrongKey) && keyring.dropStale() {
+		return addAuditGroup(audit, sherlock, keyring, gid)
+	}
+	if err != nil {
+		return err
+	}
+	audit.Add(group)
+	return nil
+}
+
+func reportPrinter(report internal.Report) printer {
+	p := printer{
+		value:  report,
+		header: []string{"check", "accounts", "detail"},
+	}
+	for _, f := range report.Findings {
+		p.items = append(p.items, f)
+		p.rows = append(p.rows, []string{f.Check, strings.Join(f.Accounts, " "), f.Detail})
+	}
+	p.table = func() {
+		summary := fmt.Sprintf("score %d/100 (%d account(s) in %d group(s))", report.Score, report.Accounts, report.Groups)
+		if len(report.Findings) == 0 {
+			if len(report.Skipped) == 0 {
+				terminal.Success("%s, no issues found", summary)
+				return
+			}
+		}
+		terminal.Warning(summary)
+		for _, skipped := range report.Skipped {
+			terminal.Warning("group %q not audited: %s", skipped.Group, skipped.Reason)
+		}
+		var counts [][]string
+		for _, check := range internal.Checks {
+			if n, ok := report.Summary[check]; ok {
+				counts = append(counts, []string{check, strconv.Itoa(n)})
+			}
+		}
+		terminal.ToTable([]string{"Check", "Accounts"}, counts)
+
+		var rows [][]string
+		for _, f := range report.Findings {
+			rows = append(rows, []string{f.Check, strings.Join(f.Accounts, "\n"), f.Detail})
+		}
+		terminal.ToTable([]string{"Check", "Accounts", "Detail"}, rows, terminal.TableWithCellMerge(0))
+	}
+	return p
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// warnBreached warns if the password appears in the Pwned Passwords
+// file of the config. Without a file nothing is checked
+func warnBreached(cfg *viper.Viper, password string) {
+	path := expandHome(cfg.GetString(configHIBPFile))
+	if path == "" {
+		return
+	}
+	dump, err := hibp.Open(path)
+	if err != nil {
+		terminal.Warning("cannot check for breached passwords: %v", err)
+		return
+	}
+	defer dump.Close()
+	count, err := dump.Count(password)
+	if err != nil {
+		terminal.Warning("cannot check for breached passwords: %v", err)
+		return
+	}
+	if count > 0 {
+		terminal.Warning("password appeared %d times in data breaches, consider a different one", count)
+	}
+}
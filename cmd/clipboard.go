@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/KonstantinGasser/sherlock/terminal"
+	"github.com/atotto/clipboard"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// configClipboardClear enables clearing the clipboard after
+	// a value has been copied
+	configClipboardClear = "clipboard.clear"
+	// configClipboardClearAfter is the delay after which the
+	// clipboard is cleared
+	configClipboardClearAfter = "clipboard.clear-after"
+
+	// clipboardClearCmd is the hidden command run by the detached
+	// helper process clearing the clipboard
+	clipboardClearCmd = "clipboard-clear"
+)
+
+// copyToClipboard copies the value to the clipboard. If configured, a detached
+// helper process clears the clipboard after the configured delay as long as the
+// clipboard still holds the value
+func copyToClipboard(cfg *viper.Viper, value string, noClear bool) error {
+	if err := clipboard.WriteAll(value); err != nil {
+		return err
+	}
+	if noClear || !cfg.GetBool(configClipboardClear) {
+		return nil
+	}
+	after := cfg.GetDuration(configClipboardClearAfter)
+	if err := spawnClipboardClear(value, after); err != nil {
+		terminal.Warning("clipboard will not be cleared: %v", err)
+		return nil
+	}
+	terminal.Info("clipboard will be cleared in %v", after)
+	return nil
+}
+
+// spawnClipboardClear starts the clipboard-clear command in a detached process.
+// The helper only learns the digest of the value through its stdin so the value
+// itself never shows up in the process list or environment
+func spawnClipboardClear(value string, after time.Duration) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	helper := exec.Command(exe, clipboardClearCmd, "--after", after.String())
+	detach(helper)
+	stdin, err := helper.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := helper.Start(); err != nil {
+		return err
+	}
+	if _, err := stdin.Write([]byte(clipboardDigest(value) + "\n")); err != nil {
+		return err
+	}
+	if err := stdin.Close(); err != nil {
+		return err
+	}
+	return helper.Process.Release()
+}
+
+func clipboardDigest(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+type clipboardClearOptions struct {
+	after time.Duration
+}
+
+// cmdClipboardClear is run by the detached helper process started
+// through spawnClipboardClear. It reads the digest of the copied value
+// from stdin
+func cmdClipboardClear() *cobra.Command {
+	var opts clipboardClearOptions
+	clear := &cobra.Command{
+		Use:    clipboardClearCmd,
+		Short:  "clear the clipboard if it still holds the copied value",
+		Hidden: true,
+		Args:   cobra.ExactArgs(0),
+		Run: func(cmd *cobra.Command, args []string) {
+			digest, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil {
+				return
+			}
+			time.Sleep(opts.after)
+
+			current, err := clipboard.ReadAll()
+			if err != nil {
+				return
+			}
+			// only clear the clipboard if the user did not copy something else
+			if subtle.ConstantTimeCompare([]byte(clipboardDigest(current)), []byte(strings.TrimSpace(digest))) != 1 {
+				return
+			}
+			_ = clipboard.WriteAll("")
+		},
+	}
+	clear.Flags().DurationVar(&opts.after, "after", 30*time.Second, "delay before the clipboard is cleared")
+	return clear
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/KonstantinGasser/sherlock/agent"
+	"github.com/KonstantinGasser/sherlock/fs"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	// configVaultDir is the directory holding all groups and vaults
+	configVaultDir = "vault-dir"
+	// configLockTimeout is the time to wait for a group locked by
+	// another sherlock process
+	configLockTimeout = "lock-timeout"
+
+	// envConfigFile allows to point sherlock to another config file
+	envConfigFile = "SHERLOCK_CONFIG"
+	// envVaultDir overwrites the vault directory from the config file
+	envVaultDir = "SHERLOCK_HOME"
+)
+
+// newConfig creates the sherlock configuration.
+//
+// settings are resolved in the following order: command line flag,
+// environment variable (SHERLOCK_<SETTING>), config file and the default.
+// The flags passed in are bound to the settings with the same name.
+func newConfig(flags *pflag.FlagSet) *viper.Viper {
+	cfg := viper.New()
+	cfg.SetEnvPrefix("sherlock")
+	cfg.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
+	cfg.AutomaticEnv()
+	_ = cfg.BindEnv(configVaultDir, envVaultDir)
+
+	cfg.SetDefault(configVaultDir, fs.DefaultRoot())
+	cfg.SetDefault(configLockTimeout, 5*time.Second)
+	cfg.SetDefault(configClipboardClear, true)
+	cfg.SetDefault(configClipboardClearAfter, 30*time.Second)
+	cfg.SetDefault(configEnvKeys, false)
+	cfg.SetDefault(configAgentSocket, agent.SocketPath())
+	cfg.SetDefault(configAgentIdleTimeout, 15*time.Minute)
+	cfg.SetDefault(configAgentMaxLifetime, 4*time.Hour)
+	cfg.SetDefault(configHIBPFile, "")
+	cfg.SetDefault(configAuditMinScore, 0)
+
+	_ = cfg.BindPFlags(flags)
+	return cfg
+}
+
+// readConfig loads the config file. A missing config
+// file is not an error
+func readConfig(cfg *viper.Viper) error {
+	cfg.SetConfigFile(configPath())
+	if err := cfg.ReadInConfig(); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// configPath returns the path of the config file
+// => $XDG_CONFIG_HOME/sherlock/config.yaml (default ~/.config)
+// unless set through SHERLOCK_CONFIG
+func configPath() string {
+	if path := os.Getenv(envConfigFile); path != "" {
+		return path
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, _ := os.UserHomeDir()
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "sherlock", "config.yaml")
+}
+
+// vaultDir returns the configured vault directory with
+// a leading "~" expanded to the home directory
+func vaultDir(cfg *viper.Viper) string {
+	return expandHome(cfg.GetString(configVaultDir))
+}
+
+// expandHome expands a leading "~" of a path to the home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, _ := os.UserHomeDir()
+		path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+	}
+	return path
+}
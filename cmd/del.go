@@ -2,23 +2,24 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/KonstantinGasser/sherlock/internal"
 	"github.com/KonstantinGasser/sherlock/terminal"
 	"github.com/spf13/cobra"
 )
 
-func cmdDel(ctx context.Context, sherlock *internal.Sherlock) *cobra.Command {
+func cmdDel(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
 	del := &cobra.Command{
 		Use:   "del",
 		Short: "delete a group or account from sherlock",
 		Long:  "delete a group or account from sherlock",
-		Run: func(cmd *cobra.Command, args []string) {
-			_ = cmd.Help()
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
 		},
 	}
-	del.AddCommand(cmdDelAccount(ctx, sherlock))
-	del.AddCommand(cmdDelGroup(ctx, sherlock))
+	del.AddCommand(cmdDelAccount(ctx, sherlock, keyring))
+	del.AddCommand(cmdDelGroup(ctx, sherlock, keyring))
 
 	return del
 }
@@ -27,45 +28,45 @@ type delGroupOptions struct {
 	force bool
 }
 
-func cmdDelGroup(ctx context.Context, sherlock *internal.Sherlock) *cobra.Command {
+func cmdDelGroup(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
 	var opts delGroupOptions
 	group := &cobra.Command{
 		Use:   "group",
 		Short: "delete a group",
 		Long:  "delete a group from sherlock (irreversible, all mapped accounts will be deleted as well)",
 		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) <= 0 {
-				terminal.Error("group key required")
-				return
+				return fmt.Errorf("group key required")
 			}
-			groupKey, err := terminal.ReadPassword("(%s) password: ", args[0])
+			// deleting a group is irreversible, a key
+			// cached by the agent is not enough
+			groupKey, err := keyring.readUncached(args[0], "password")
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 			group, err := sherlock.LoadGroup(args[0], groupKey)
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 			if !opts.force {
 				// show verbose output of all account which will be deleted
 				terminal.Warning("following accounts will be deleted with the group:")
 				terminal.ToTable(
 					[]string{"Group", "Account", "#Tag", "Created On"},
-					group.Table(false),
+					group.Table(false, false),
 					terminal.TableWithCellMerge(0),
 				)
 				if yes := terminal.YesNo("delete group with [y/N]: "); !yes {
-					return
+					return nil
 				}
 			}
 			if err := sherlock.DeleteGroup(ctx, args[0]); err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
+			keyring.forget(args[0])
 			terminal.Success("group %q successfully deleted!", args[0])
+			return nil
 		},
 	}
 	group.Flags().BoolVarP(&opts.force, "force", "f", false, "bypass confirmation dialog")
@@ -76,36 +77,34 @@ type delAccOptions struct {
 	force bool
 }
 
-func cmdDelAccount(ctx context.Context, sherlock *internal.Sherlock) *cobra.Command {
+func cmdDelAccount(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
 	var opts delAccOptions
 	del := &cobra.Command{
 		Use:   "account",
 		Short: "delete an account from a group",
 		Long:  "delete an account from a group",
 		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) <= 0 {
-				terminal.Error("account key required (group@account)")
-				return
+				return fmt.Errorf("account key required (group@account)")
 			}
 
-			groupKey, err := terminal.ReadPassword("(%s) password: ", args[0])
+			groupKey, err := keyring.read(args[0], "password")
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 			if !opts.force {
 				confirm := terminal.YesNo("delete account [y/N]: ")
 				if !confirm {
-					return
+					return nil
 				}
 			}
 
 			if err := sherlock.UpdateState(ctx, args[0], groupKey, internal.OptAccDelete()); err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 			terminal.Success("account %q successfully deleted", args[0])
+			return nil
 		},
 	}
 	del.Flags().BoolVarP(&opts.force, "force", "f", false, "will bypass [y/N] prompt")
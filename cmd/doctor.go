@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/KonstantinGasser/sherlock/fs"
+	"github.com/KonstantinGasser/sherlock/internal"
+	"github.com/KonstantinGasser/sherlock/security"
+	"github.com/KonstantinGasser/sherlock/terminal"
+	"github.com/spf13/cobra"
+)
+
+// permissionAuditor is implemented by file systems which can check
+// the permissions of the files and directories of sherlock (see fs.Fs)
+type permissionAuditor interface {
+	AuditPermissions() ([]fs.PermissionIssue, error)
+	FixPermissions(issues []fs.PermissionIssue) error
+}
+
+type doctorOptions struct {
+	kdfBenchmark bool
+	kdfTarget    time.Duration
+}
+
+func cmdDoctor(ctx context.Context, sherlock *internal.Sherlock, fileSystem *internal.FileSystem) *cobra.Command {
+	var opts doctorOptions
+	doctor := &cobra.Command{
+		Use:   "doctor",
+		Short: "inspect the sherlock set-up",
+		Long:  "inspect the sherlock set-up for files accessible by other users and offer to fix them. With --kdf-benchmark the key derivation parameters are tuned for this machine",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.kdfBenchmark {
+				return kdfBenchmark(sherlock, opts.kdfTarget)
+			}
+			if auditor, ok := (*fileSystem).(permissionAuditor); ok {
+				if err := auditPermissions(auditor); err != nil {
+					return err
+				}
+			} else {
+				terminal.Warning("file permissions can not be checked for this vault")
+			}
+
+			params, err := sherlock.KDFParams()
+			if err != nil {
+				return err
+			}
+			terminal.Info("key derivation parameters for new vault writes:")
+			terminal.ToTable(
+				[]string{"KDF", "Time", "Memory", "Threads"},
+				[][]string{kdfRow(params)},
+			)
+			return nil
+		},
+	}
+	doctor.Flags().BoolVar(&opts.kdfBenchmark, "kdf-benchmark", false, "pick key derivation parameters for this machine")
+	doctor.Flags().DurationVar(&opts.kdfTarget, "kdf-target", time.Second, "time one key derivation should take")
+
+	return doctor
+}
+
+// auditPermissions reports files and directories with too permissive
+// permissions or a wrong owner and fixes them if the user agrees
+func auditPermissions(auditor permissionAuditor) error {
+	issues, err := auditor.AuditPermissions()
+	if err != nil {
+		return err
+	}
+	if len(issues) == 0 {
+		terminal.Success("file permissions are fine")
+		return nil
+	}
+
+	var rows [][]string
+	var fixable bool
+	for _, issue := range issues {
+		rows = append(rows, []string{
+			issue.Path,
+			fmt.Sprintf("%04o", issue.Mode),
+			fmt.Sprintf("%04o", issue.Want),
+			issue.Problem,
+		})
+		fixable = fixable || issue.Fixable
+	}
+	terminal.Warning("found %d permission issue(s):", len(issues))
+	terminal.ToTable([]string{"Path", "Mode", "Expected", "Problem"}, rows)
+	if !fixable {
+		return nil
+	}
+	if yes := terminal.YesNo("restrict permissions to the current user [y/N]: "); !yes {
+		return nil
+	}
+	if err := auditor.FixPermissions(issues); err != nil {
+		return err
+	}
+	terminal.Success("permissions fixed")
+	return nil
+}
+
+// kdfBenchmark measures the key derivation on this machine and
+// stores the resulting parameters if the user agrees
+func kdfBenchmark(sherlock *internal.Sherlock, target time.Duration) error {
+	terminal.Info("benchmarking argon2id (target %v per key derivation)...", target)
+	params, took := security.BenchmarkKDF(target)
+
+	terminal.ToTable(
+		[]string{"KDF", "Time", "Memory", "Threads", "Took"},
+		[][]string{append(kdfRow(params), took.Round(time.Millisecond).String())},
+	)
+	if yes := terminal.YesNo("use these parameters for future vault writes [y/N]: "); !yes {
+		return nil
+	}
+	if err := sherlock.SaveKDFParams(params); err != nil {
+		return err
+	}
+	terminal.Success("key derivation parameters saved. Groups pick them up the next time they are written with their password")
+	return nil
+}
+
+func kdfRow(params security.KDFParams) []string {
+	return []string{
+		"argon2id",
+		fmt.Sprintf("%d", params.Time),
+		fmt.Sprintf("%d MiB", params.Memory/1024),
+		fmt.Sprintf("%d", params.Threads),
+	}
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/KonstantinGasser/sherlock/internal"
+	"github.com/KonstantinGasser/sherlock/terminal"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ErrInvalidEnv   = fmt.Errorf("invalid --env, expected NAME=group@account[/field]")
+	ErrDuplicateEnv = fmt.Errorf("environment variable set twice")
+	envNameRegex    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ExitStatus is returned by a command which ends sherlock with the exit
+// code without an error message. exec passes on the exit code of the
+// command it ran with it
+type ExitStatus int
+
+func (status ExitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(status))
+}
+
+type execOptions struct {
+	env          []string
+	onlyTheseEnv bool
+}
+
+func cmdExec(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
+	var opts execOptions
+	execCmd := &cobra.Command{
+		Use:   "exec -- command [args...]",
+		Short: "run a command with secrets as environment variables",
+		Long:  "exec runs a command with account passwords or fields set as environment variables (--env NAME=group@account[/field]). Every group is unlocked once. The exit code of the command is passed on",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			names, queries, err := parseEnv(opts.env)
+			if err != nil {
+				return err
+			}
+			values, err := sherlock.GetFields(queries, func(gid string) (string, error) {
+				return keyring.read(gid, "password")
+			})
+			if err != nil {
+				return err
+			}
+
+			env := os.Environ()
+			if opts.onlyTheseEnv {
+				env = nil
+			}
+			for i, name := range names {
+				env = append(env, name+"="+values[i])
+			}
+			if code := run(args[0], args[1:], env); code != 0 {
+				return ExitStatus(code)
+			}
+			return nil
+		},
+	}
+	execCmd.Flags().StringArrayVarP(&opts.env, "env", "e", nil, "environment variable as NAME=group@account[/field] (repeatable)")
+	execCmd.Flags().BoolVar(&opts.onlyTheseEnv, "only-these-env", false, "start the command with only the --env variables set")
+	// flags following the command belong to the command
+	execCmd.Flags().SetInterspersed(false)
+
+	return execCmd
+}
+
+// parseEnv splits the NAME=query pairs of the --env flag
+func parseEnv(pairs []string) ([]string, []string, error) {
+	var names, queries []string
+	seen := make(map[string]bool)
+	for _, pair := range pairs {
+		set := strings.SplitN(pair, "=", 2)
+		if len(set) != 2 || !envNameRegex.MatchString(set[0]) {
+			return nil, nil, fmt.Errorf("%w: %q", ErrInvalidEnv, pair)
+		}
+		if _, _, _, err := internal.SplitFieldQuery(set[1]); err != nil {
+			return nil, nil, fmt.Errorf("%w: %q", ErrInvalidEnv, pair)
+		}
+		if seen[set[0]] {
+			return nil, nil, fmt.Errorf("%w: %s", ErrDuplicateEnv, set[0])
+		}
+		seen[set[0]] = true
+		names = append(names, set[0])
+		queries = append(queries, set[1])
+	}
+	return names, queries, nil
+}
+
+// run starts the command with the environment, forwards interrupts
+// and returns the exit code of the command
+func run(name string, args []string, env []string) int {
+	child := exec.Command(name, args...)
+	child.Env = env
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	if err := child.Start(); err != nil {
+		terminal.Error(err.Error())
+		return 127
+	}
+	go func() {
+		for s := range sig {
+			_ = child.Process.Signal(s)
+		}
+	}()
+
+	err := child.Wait()
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		terminal.Error(err.Error())
+		return 1
+	}
+	// a command killed by a signal reports -1 as exit code
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/KonstantinGasser/sherlock/security"
+	"github.com/KonstantinGasser/sherlock/terminal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrCopyCount = fmt.Errorf("--copy can only be used for a single password (--count 1)")
+)
+
+// generatorOptions are the password policy flags shared by
+// generate, add account and update password
+type generatorOptions struct {
+	length       int
+	noLower      bool
+	noUpper      bool
+	noDigits     bool
+	noSymbols    bool
+	symbols      string
+	exclude      string
+	allowSimilar bool
+	minLower     int
+	minUpper     int
+	minDigits    int
+	minSymbols   int
+}
+
+func bindGeneratorFlags(flags *pflag.FlagSet, opts *generatorOptions) {
+	flags.IntVarP(&opts.length, "len", "l", security.DefaultPolicy.Length, "length of the generated password")
+	flags.BoolVar(&opts.noLower, "no-lower", false, "do not use lower case letters")
+	flags.BoolVar(&opts.noUpper, "no-upper", false, "do not use upper case letters")
+	flags.BoolVar(&opts.noDigits, "no-digits", false, "do not use digits")
+	flags.BoolVar(&opts.noSymbols, "no-symbols", false, "do not use symbols")
+	flags.StringVar(&opts.symbols, "symbols", "", "symbols to pick from instead of the default symbols")
+	flags.StringVar(&opts.exclude, "exclude", "", "characters never to use")
+	flags.BoolVar(&opts.allowSimilar, "allow-similar", false, "allow easily confused characters like l, I, O or 0")
+	flags.IntVar(&opts.minLower, "min-lower", 0, "minimum number of lower case letters")
+	flags.IntVar(&opts.minUpper, "min-upper", 0, "minimum number of upper case letters")
+	flags.IntVar(&opts.minDigits, "min-digits", 0, "minimum number of digits")
+	flags.IntVar(&opts.minSymbols, "min-symbols", 0, "minimum number of symbols")
+}
+
+func (opts generatorOptions) policy() (security.Policy, error) {
+	p := security.Policy{
+		Length:       opts.length,
+		Lower:        !opts.noLower,
+		Upper:        !opts.noUpper,
+		Digits:       !opts.noDigits,
+		Symbols:      !opts.noSymbols,
+		SymbolSet:    opts.symbols,
+		Exclude:      opts.exclude,
+		AllowSimilar: opts.allowSimilar,
+		MinLower:     opts.minLower,
+		MinUpper:     opts.minUpper,
+		MinDigits:    opts.minDigits,
+		MinSymbols:   opts.minSymbols,
+	}
+	return p, p.Valid()
+}
+
+// passphraseOptions are the passphrase policy flags
+type passphraseOptions struct {
+	words      int
+	separator  string
+	capitalize bool
+	digit      bool
+}
+
+func bindPassphraseFlags(flags *pflag.FlagSet, opts *passphraseOptions) {
+	flags.IntVar(&opts.words, "words", security.DefaultPassphrasePolicy.Words, "number of words of the passphrase")
+	flags.StringVar(&opts.separator, "separator", security.DefaultPassphrasePolicy.Separator, "separator between the words of the passphrase")
+	flags.BoolVar(&opts.capitalize, "capitalize", false, "capitalize the words of the passphrase")
+	flags.BoolVar(&opts.digit, "digit", false, "append a random digit to a random word of the passphrase")
+}
+
+func (opts passphraseOptions) policy() (security.PassphrasePolicy, error) {
+	p := security.PassphrasePolicy{
+		Words:      opts.words,
+		Separator:  opts.separator,
+		Capitalize: opts.capitalize,
+		Digit:      opts.digit,
+	}
+	return p, p.Valid()
+}
+
+// generatePassword generates a password following the policy of
+// the flags and reports its entropy estimate
+func generatePassword(opts generatorOptions) (string, error) {
+	policy, err := opts.policy()
+	if err != nil {
+		return "", err
+	}
+	password, err := security.Generate(policy)
+	if err != nil {
+		return "", err
+	}
+	terminal.Info("generated password (~%.0f bits)", policy.Entropy())
+	return password, nil
+}
+
+// suggestPassphrase offers generated passphrases until the user picks one.
+// An empty passphrase is returned if the user declines all suggestions
+func suggestPassphrase(opts passphraseOptions) (string, error) {
+	policy, err := opts.policy()
+	if err != nil {
+		return "", err
+	}
+	for {
+		passphrase, err := security.GeneratePassphrase(policy)
+		if err != nil {
+			return "", err
+		}
+		terminal.Info("suggested passphrase (~%.0f bits): %s", policy.Entropy(), passphrase)
+		answer, err := terminal.ReadLine("use it [y]es, [n]ext or [q]uit and enter a password: ")
+		if err != nil {
+			return "", err
+		}
+		switch strings.TrimSpace(answer) {
+		case "y":
+			return passphrase, nil
+		case "n":
+			continue
+		default:
+			return "", nil
+		}
+	}
+}
+
+// generatedPassword is the printed representation of
+// a generated password
+type generatedPassword struct {
+	Password string  `json:"password" yaml:"password"`
+	Entropy  float64 `json:"entropy_bits" yaml:"entropy_bits"`
+}
+
+type generateOptions struct {
+	count      int
+	copy       bool
+	show       bool
+	passphrase bool
+	generatorOptions
+	passphraseOptions
+	outputOptions
+}
+
+// generator returns the function generating a password or passphrase
+// as configured by the flags and the entropy of its results
+func (opts generateOptions) generator() (func() (string, error), float64, error) {
+	if opts.passphrase {
+		policy, err := opts.passphraseOptions.policy()
+		if err != nil {
+			return nil, 0, err
+		}
+		return func() (string, error) { return security.GeneratePassphrase(policy) }, policy.Entropy(), nil
+	}
+	policy, err := opts.generatorOptions.policy()
+	if err != nil {
+		return nil, 0, err
+	}
+	return func() (string, error) { return security.Generate(policy) }, policy.Entropy(), nil
+}
+
+func cmdGenerate(ctx context.Context, cfg *viper.Viper) *cobra.Command {
+	var opts generateOptions
+	generate := &cobra.Command{
+		Use:   "generate",
+		Short: "generate random passwords",
+		Long:  "generate random passwords following a policy of character classes, symbol sets and minimum counts per class or with --passphrase passphrases of words from the EFF wordlist. The entropy estimate is printed next to each password",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			next, bits, err := opts.generator()
+			if err != nil {
+				return err
+			}
+			if opts.count < 1 {
+				return fmt.Errorf("--count must be at least 1")
+			}
+			if opts.copy && opts.count != 1 {
+				return ErrCopyCount
+			}
+			entropy := fmt.Sprintf("~%.0f bits", bits)
+
+			var passwords []string
+			for i := 0; i < opts.count; i++ {
+				password, err := next()
+				if err != nil {
+					return err
+				}
+				passwords = append(passwords, password)
+			}
+
+			if opts.copy {
+				if err := copyToClipboard(cfg, passwords[0], false); err != nil {
+					return err
+				}
+				terminal.Success("password copied to clipboard (%s)", entropy)
+				if !opts.show {
+					return nil
+				}
+			}
+
+			p := printer{header: []string{"password", "entropy_bits"}}
+			var values []generatedPassword
+			for _, password := range passwords {
+				value := generatedPassword{Password: password, Entropy: bits}
+				values = append(values, value)
+				p.items = append(p.items, value)
+				p.rows = append(p.rows, []string{password, fmt.Sprintf("%.0f", bits)})
+				p.plain = append(p.plain, password+"\t"+entropy)
+			}
+			p.value = values
+			p.table = func() {
+				var rows [][]string
+				for _, password := range passwords {
+					rows = append(rows, []string{password, entropy})
+				}
+				terminal.ToTable([]string{"Password", "Entropy"}, rows)
+			}
+			return opts.print(p)
+		},
+	}
+	generate.Flags().IntVarP(&opts.count, "count", "n", 1, "number of passwords to generate")
+	generate.Flags().BoolVarP(&opts.copy, "copy", "c", false, "copy the password to the clipboard instead of printing it")
+	generate.Flags().BoolVarP(&opts.show, "print", "p", false, "print the password (default unless --copy is set)")
+	generate.Flags().BoolVar(&opts.passphrase, "passphrase", false, "generate a passphrase of words instead of a password")
+	bindGeneratorFlags(generate.Flags(), &opts.generatorOptions)
+	bindPassphraseFlags(generate.Flags(), &opts.passphraseOptions)
+	bindOutputFlags(generate.Flags(), &opts.outputOptions)
+
+	return generate
+}
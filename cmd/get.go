@@ -2,42 +2,79 @@ package cmd
 
 import (
 	"context"
+	"os"
+	"time"
 
 	"github.com/KonstantinGasser/sherlock/internal"
 	"github.com/KonstantinGasser/sherlock/terminal"
-	"github.com/atotto/clipboard"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 type getOptions struct {
 	verbose bool
+	noClear bool
+	outputOptions
 }
 
-func cmdGet(ctx context.Context, sherlock *internal.Sherlock) *cobra.Command {
+// fieldInfo is the printed representation of a queried value
+type fieldInfo struct {
+	Group   string `json:"group" yaml:"group"`
+	Account string `json:"account" yaml:"account"`
+	Field   string `json:"field" yaml:"field"`
+	Value   string `json:"value" yaml:"value"`
+}
+
+func cmdGet(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys, cfg *viper.Viper) *cobra.Command {
 	var opts getOptions
 	get := &cobra.Command{
 		Use:   "get",
-		Short: "get retrieves a stored password from a group",
-		Long:  "with the get command you can query an accounts password from a specific group",
+		Short: "get retrieves a stored password or account field from a group",
+		Long:  "with the get command you can query an accounts password (group@account) or any other account field (group@account/field) from a specific group",
 		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
-			groupKey, err := terminal.ReadPassword("(%s) password: ", args[0])
+		RunE: func(cmd *cobra.Command, args []string) error {
+			gid, name, field, err := internal.SplitFieldQuery(args[0])
+			if err != nil {
+				return err
+			}
+			mode, err := opts.mode()
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
-			account, err := sherlock.GetAccount(args[0], groupKey)
+			groupKey, err := keyring.read(args[0], "password")
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
+			}
+			value, err := sherlock.GetField(args[0], groupKey)
+			if err != nil {
+				return err
+			}
+			// anything but the table output prints the value instead
+			// of copying it to the clipboard
+			if mode != outputTable {
+				if field == "" {
+					field = "password"
+				}
+				info := fieldInfo{Group: gid, Account: name, Field: field, Value: value}
+				return opts.printTo(os.Stdout, mode, printer{
+					value:  info,
+					items:  []interface{}{info},
+					header: []string{"group", "account", "field", "value"},
+					rows:   [][]string{{gid, name, field, value}},
+					plain:  []string{value},
+				})
 			}
 			if opts.verbose {
-				terminal.Info(account.Password)
+				terminal.Info(value)
 			}
-			clipboard.WriteAll(account.Password)
+			return copyToClipboard(cfg, value, opts.noClear)
 		},
 	}
-	get.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "print plain password to cli")
+	get.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "print plain password (or field) to cli")
+	get.Flags().BoolVar(&opts.noClear, "no-clear", false, "do not clear the clipboard")
+	get.Flags().Duration("clear-after", 30*time.Second, "delay after which the clipboard is cleared")
+	bindOutputFlags(get.Flags(), &opts.outputOptions)
+	_ = cfg.BindPFlag(configClipboardClearAfter, get.Flags().Lookup("clear-after"))
 
 	return get
 }
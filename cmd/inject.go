@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/KonstantinGasser/sherlock/inject"
+	"github.com/KonstantinGasser/sherlock/internal"
+	"github.com/KonstantinGasser/sherlock/terminal"
+	"github.com/spf13/cobra"
+)
+
+// injectFilePerm is the permission of rendered files
+// since they hold secrets
+const injectFilePerm = 0600
+
+type injectOptions struct {
+	input  string
+	output string
+	dryRun bool
+}
+
+func cmdInject(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
+	var opts injectOptions
+	injectCmd := &cobra.Command{
+		Use:   "inject",
+		Short: "render a template with secrets from sherlock",
+		Long:  "inject replaces references like {{ sherlock \"group@account\" }} or sherlock://group/account/field in a template with the values from sherlock. Any other template text is left untouched. A reference to a missing group, account or field fails the whole render",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tmpl, err := readInput(opts.input)
+			if err != nil {
+				return err
+			}
+			refs, err := inject.References(tmpl)
+			if err != nil {
+				return err
+			}
+			if opts.dryRun {
+				var rows [][]string
+				for _, ref := range refs {
+					gid, name, field, _ := internal.SplitFieldQuery(ref)
+					if field == "" {
+						field = "password"
+					}
+					rows = append(rows, []string{ref, gid, name, field})
+				}
+				terminal.ToTable([]string{"Reference", "Group", "Account", "Field"}, rows)
+				return nil
+			}
+
+			values, err := sherlock.GetFields(refs, func(gid string) (string, error) {
+				return keyring.read(gid, "password")
+			})
+			if err != nil {
+				return err
+			}
+			resolved := make(map[string]string, len(refs))
+			for i, ref := range refs {
+				resolved[ref] = values[i]
+			}
+			// render into memory first so a failing render
+			// never leaves a partial file behind
+			var out bytes.Buffer
+			if err := inject.Render(&out, tmpl, func(query string) (string, error) {
+				return resolved[query], nil
+			}); err != nil {
+				return err
+			}
+
+			if opts.output == "" || opts.output == "-" {
+				_, _ = os.Stdout.Write(out.Bytes())
+				return nil
+			}
+			if err := writeSecretFile(opts.output, out.Bytes()); err != nil {
+				return err
+			}
+			terminal.Success("%d reference(s) rendered to %s", len(refs), opts.output)
+			return nil
+		},
+	}
+	injectCmd.Flags().StringVarP(&opts.input, "input", "i", "-", "template file (default stdin)")
+	injectCmd.Flags().StringVarP(&opts.output, "output", "o", "-", "rendered file, written with mode 0600 (default stdout)")
+	injectCmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "list the references without resolving them")
+
+	return injectCmd
+}
+
+func readInput(path string) (string, error) {
+	if path == "" || path == "-" {
+		b, err := ioutil.ReadAll(os.Stdin)
+		return string(b), err
+	}
+	b, err := ioutil.ReadFile(path)
+	return string(b), err
+}
+
+// writeSecretFile replaces the file with the data. The data is written to a
+// temp file only readable by the current user which is renamed to the file
+// so neither a partial file nor a file with looser permissions is left
+func writeSecretFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), injectFilePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
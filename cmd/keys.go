@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/KonstantinGasser/sherlock/agent"
+	"github.com/KonstantinGasser/sherlock/internal"
+	"github.com/KonstantinGasser/sherlock/keys"
+	"github.com/KonstantinGasser/sherlock/terminal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// configEnvKeys allows group keys to be read from
+// SHERLOCK_KEY_<GROUP> environment variables
+const configEnvKeys = "env-keys"
+
+var (
+	ErrKeySources = fmt.Errorf("only one of --key-file, --key-fd and --key-stdin can be used")
+)
+
+// keyOptions are the non-interactive key sources set through
+// global flags. They are not part of the configuration on purpose
+type keyOptions struct {
+	file  string
+	fd    int
+	stdin bool
+}
+
+func bindKeyFlags(flags *pflag.FlagSet, opts *keyOptions) {
+	flags.StringVar(&opts.file, "key-file", "", "read the group password from the first line of a file")
+	flags.IntVar(&opts.fd, "key-fd", -1, "read the group password from the first line of an open file descriptor")
+	flags.BoolVar(&opts.stdin, "key-stdin", false, "read the group password from the first line of stdin")
+}
+
+// groupKeys resolves group keys from the configured key providers
+// and the sherlock agent before falling back to the password prompt
+type groupKeys struct {
+	provider keys.Provider
+	agent    *agent.Client
+	// root is the vault directory. Keys in the agent are stored per
+	// vault directory so groups of the same name do not share a key
+	root string
+	// verify checks whether a key still unlocks its group
+	verify func(gid, key string) error
+	// served are the keys taken from the agent by group
+	served map[string]string
+}
+
+// newKeyProvider chains the key sources in the order: key flag,
+// SHERLOCK_KEY_<GROUP> (if enabled in the config)
+func newKeyProvider(opts keyOptions, cfg *viper.Viper) (keys.Provider, error) {
+	var chain keys.Chain
+	if opts.file != "" {
+		chain = append(chain, keys.File(opts.file))
+	}
+	if opts.fd >= 0 {
+		chain = append(chain, keys.FD(opts.fd))
+	}
+	if opts.stdin {
+		chain = append(chain, keys.Stdin())
+	}
+	if len(chain) > 1 {
+		return nil, ErrKeySources
+	}
+	if cfg.GetBool(configEnvKeys) {
+		chain = append(chain, keys.Env(os.LookupEnv))
+	}
+	return chain, nil
+}
+
+// read returns the key of the group addressed by the query (group or
+// group@account). Without a key from any provider or the agent the
+// user is prompted
+func (k *groupKeys) read(query, prompt string) (string, error) {
+	return k.resolve(query, prompt, true)
+}
+
+// readUncached is like read but never uses a key cached by the agent
+func (k *groupKeys) readUncached(query, prompt string) (string, error) {
+	return k.resolve(query, prompt, false)
+}
+
+// readNew returns the key for a new group or a new key of a group. Without
+// a key from any provider the user is prompted twice (see readNewPassword)
+func (k *groupKeys) readNew(query, prompt string, minEntropy float64, noConfirm bool) (string, error) {
+	if k.provider != nil {
+		key, ok, err := k.provider.Key(strings.SplitN(query, "@", 2)[0])
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return key, nil
+		}
+	}
+	return readNewPassword(query, prompt, minEntropy, noConfirm)
+}
+
+func (k *groupKeys) resolve(query, prompt string, cached bool) (string, error) {
+	gid := strings.SplitN(query, "@", 2)[0]
+	if k.provider != nil {
+		key, ok, err := k.provider.Key(gid)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return key, nil
+		}
+	}
+	if cached && k.agent != nil {
+		// a broken agent must not lock the user out
+		key, ok, err := k.agent.Key(k.agentID(gid))
+		if err != nil {
+			terminal.Warning("sherlock agent: %v", err)
+		}
+		if ok {
+			if k.served == nil {
+				k.served = make(map[string]string)
+			}
+			k.served[gid] = key
+			return key, nil
+		}
+	}
+	return terminal.ReadPassword("(%s) %s: ", query, prompt)
+}
+
+// dropStale drops the keys taken from the agent which no longer unlock
+// their group, for example since another command wrote the group with its
+// password. It reports whether a key was dropped
+func (k *groupKeys) dropStale() bool {
+	var dropped bool
+	for gid, key := range k.served {
+		if !errors.Is(k.verify(gid, key), internal.ErrWrongKey) {
+			continue
+		}
+		terminal.Warning("sherlock agent: key of %q is no longer valid", gid)
+		k.forget(gid)
+		delete(k.served, gid)
+		dropped = true
+	}
+	return dropped
+}
+
+// retryStaleKeys runs a command failing with internal.ErrWrongKey once more
+// if it used a key of the agent which no longer unlocks its group. The stale
+// key is dropped so the command prompts for it. A wrong key fails the command
+// when it loads the group, before it changed anything
+func retryStaleKeys(cmd *cobra.Command, k *groupKeys) {
+	for _, sub := range cmd.Commands() {
+		retryStaleKeys(sub, k)
+	}
+	run := cmd.RunE
+	if run == nil {
+		return
+	}
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		err := run(cmd, args)
+		if errors.Is(err, internal.ErrWrongKey) && k.dropStale() {
+			return run(cmd, args)
+		}
+		return err
+	}
+}
+
+// agentID is the name of the key of a group in the agent
+// => <vault-dir>/<group>
+func (k *groupKeys) agentID(gid string) string {
+	return filepath.Join(k.root, gid)
+}
+
+// unlock hands the key of the group to the agent
+func (k *groupKeys) unlock(gid, key string) error {
+	return k.agent.Add(k.agentID(gid), key)
+}
+
+// lock drops the key of the group from the agent and
+// reports whether the group was unlocked
+func (k *groupKeys) lock(gid string) (bool, error) {
+	return k.agent.Remove(k.agentID(gid))
+}
+
+// forget drops the key of a group from the agent once it is no
+// longer valid since the group was deleted or its key changed
+func (k *groupKeys) forget(gid string) {
+	if k.agent == nil {
+		return
+	}
+	_, _ = k.lock(gid)
+}
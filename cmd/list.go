@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"context"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/KonstantinGasser/sherlock/internal"
 	"github.com/KonstantinGasser/sherlock/terminal"
@@ -13,9 +16,11 @@ type listOptions struct {
 	filterByTag string
 	all         bool
 	verbose     bool
+	reveal      bool
+	outputOptions
 }
 
-func cmdList(ctx context.Context, sherlock *internal.Sherlock) *cobra.Command {
+func cmdList(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
 	var opts listOptions
 
 	list := &cobra.Command{
@@ -23,51 +28,117 @@ func cmdList(ctx context.Context, sherlock *internal.Sherlock) *cobra.Command {
 		Short: "list all accounts mapped to a given group",
 		Long:  "with the list command you can inspect all accounts mapped to a given group",
 		Args:  cobra.MaximumNArgs(2),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			var gid = "default"
+			if _, err := opts.mode(); err != nil {
+				return err
+			}
 			if opts.all {
 				groupList, err := sherlock.ReadRegisteredGroups()
 				if err != nil {
-					terminal.Error(err.Error())
-					return
-				}
-				terminal.Info("Registered Groups : ")
-				for _, group := range groupList {
-					terminal.SingleRow(emoji.RadioButton, group)
+					return err
 				}
-				return
-			} else if len(args) > 0 {
+				return opts.print(groupsPrinter(groupList))
+			}
+			if len(args) > 0 {
 				gid = args[0]
 			}
-			groupKey, err := terminal.ReadPassword("(%s) password: ", gid)
+			groupKey, err := keyring.read(gid, "password")
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 			group, err := sherlock.LoadGroup(gid, groupKey)
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 
-			headers := []string{"Group", "Account", "#Tag", "Created On"}
-			if opts.verbose {
-				headers = append(headers, "Updated On", "Expires In")
+			p := accountsPrinter(group.Info(opts.reveal, internal.FilterByTag(opts.filterByTag)))
+			p.table = func() {
+				headers := []string{"Group", "Account", "#Tag", "Created On"}
+				if opts.verbose {
+					headers = append(headers, "Updated On", "Expires In", "Fields")
+				}
+				terminal.ToTable(
+					headers,
+					group.Table(
+						opts.verbose,
+						opts.reveal,
+						internal.FilterByTag(opts.filterByTag),
+					),
+					terminal.TableWithCellMerge(0),
+				)
 			}
-
-			terminal.ToTable(
-				headers,
-				group.Table(
-					opts.verbose,
-					internal.FilterByTag(opts.filterByTag),
-				),
-				terminal.TableWithCellMerge(0),
-			)
+			return opts.print(p)
 		},
 	}
 	list.Flags().StringVarP(&opts.filterByTag, "tag", "t", "", "filter accounts by tag name")
 	list.Flags().BoolVarP(&opts.all, "all", "a", false, "show all registered groups")
 	list.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "display additional information")
+	list.Flags().BoolVar(&opts.reveal, "reveal", false, "show the values of secret fields (requires --verbose)")
+	bindOutputFlags(list.Flags(), &opts.outputOptions)
 
 	return list
 }
+
+// groupInfo is the printed representation of a group
+// of list --all
+type groupInfo struct {
+	Name string `json:"name" yaml:"name"`
+}
+
+func groupsPrinter(groupList []string) printer {
+	groups := make([]groupInfo, 0, len(groupList))
+	p := printer{
+		header: []string{"name"},
+		table: func() {
+			terminal.Info("Registered Groups : ")
+			for _, group := range groupList {
+				terminal.SingleRow(emoji.RadioButton, group)
+			}
+		},
+	}
+	for _, name := range groupList {
+		groups = append(groups, groupInfo{Name: name})
+		p.items = append(p.items, groupInfo{Name: name})
+		p.rows = append(p.rows, []string{name})
+	}
+	p.value = groups
+	return p
+}
+
+func accountsPrinter(accounts []internal.AccountInfo) printer {
+	p := printer{
+		value: accounts,
+		header: []string{
+			"group", "name", "tag", "username", "url", "notes",
+			"created_on", "updated_on", "expiration", "fields",
+		},
+	}
+	for _, a := range accounts {
+		p.items = append(p.items, a)
+		p.rows = append(p.rows, []string{
+			a.Group,
+			a.Name,
+			a.Tag,
+			a.Username,
+			a.URL,
+			a.Notes,
+			a.CreatedOn.Format(time.RFC3339),
+			a.UpdatedOn.Format(time.RFC3339),
+			a.Expiration.State,
+			joinFields(a.Fields),
+		})
+	}
+	return p
+}
+
+// joinFields joins custom fields sorted by their key
+// into a single column: key=value;key=value
+func joinFields(fields map[string]string) string {
+	pairs := make([]string, 0, len(fields))
+	for key, value := range fields {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/KonstantinGasser/sherlock/internal"
+	"github.com/KonstantinGasser/sherlock/terminal"
+	"github.com/spf13/cobra"
+)
+
+const (
+	migrateUpgraded = "upgraded"
+	migrateSkipped  = "skipped"
+	migrateFailed   = "failed"
+)
+
+func cmdMigrate(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate [groups...]",
+		Short: "upgrade group vaults to the current vault format",
+		Long:  "re-encrypt all (or the given) groups still stored in the legacy vault format. Leave the password empty to skip a group",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			groups := args
+			if len(groups) == 0 {
+				registered, err := sherlock.ReadRegisteredGroups()
+				if err != nil {
+					return err
+				}
+				groups = registered
+			}
+
+			var report [][]string
+			for _, gid := range groups {
+				status, details := migrateGroup(ctx, sherlock, keyring, gid)
+				report = append(report, []string{gid, status, details})
+			}
+			terminal.ToTable(
+				[]string{"Group", "Status", "Details"},
+				report,
+			)
+			return nil
+		},
+	}
+}
+
+// migrateGroup upgrades a single group and returns its status
+// and details for the migration report
+func migrateGroup(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys, gid string) (string, string) {
+	legacy, err := sherlock.IsLegacyGroup(gid)
+	if err != nil {
+		return migrateFailed, err.Error()
+	}
+	if !legacy {
+		return migrateSkipped, "already in current format"
+	}
+	groupKey, err := keyring.read(gid, "password")
+	if err != nil {
+		return migrateFailed, err.Error()
+	}
+	if groupKey == "" {
+		return migrateSkipped, "no password provided"
+	}
+	if _, err := sherlock.MigrateGroup(ctx, gid, groupKey); err != nil {
+		return migrateFailed, err.Error()
+	}
+	return migrateUpgraded, "re-encrypted in current format"
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/KonstantinGasser/sherlock/terminal"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	outputTable    = "table"
+	outputPlain    = "plain"
+	outputJSON     = "json"
+	outputYAML     = "yaml"
+	outputCSV      = "csv"
+	outputTemplate = "template"
+)
+
+type outputOptions struct {
+	output string
+	format string
+}
+
+// bindOutputFlags adds the --output and --format flags to a command
+func bindOutputFlags(flags *pflag.FlagSet, opts *outputOptions) {
+	flags.StringVarP(&opts.output, "output", "o", "", "output format: table, json, yaml, csv or plain (default table, plain if stdout is not a terminal)")
+	flags.StringVar(&opts.format, "format", "", "print every item using a Go template (for example '{{.Name}}')")
+}
+
+// mode resolves the output format. Without an explicit format the pretty
+// table is used for terminals and plain output otherwise
+func (opts outputOptions) mode() (string, error) {
+	if opts.format != "" {
+		return outputTemplate, nil
+	}
+	switch opts.output {
+	case "":
+		if terminal.IsTTY() {
+			return outputTable, nil
+		}
+		return outputPlain, nil
+	case outputTable, outputPlain, outputJSON, outputYAML, outputCSV:
+		return opts.output, nil
+	}
+	return "", fmt.Errorf("unknown output %q (use table, json, yaml, csv or plain)", opts.output)
+}
+
+// printer holds a result in all representations required
+// to print it in every output format
+type printer struct {
+	// value is marshalled for json and yaml output
+	value interface{}
+	// items are passed one by one to the --format template
+	items []interface{}
+	// header and rows are printed as csv. Without plain the rows
+	// are printed tab separated as plain output
+	header []string
+	rows   [][]string
+	plain  []string
+	// table renders the result for the terminal
+	table func()
+}
+
+// print writes the result in the configured output format
+func (opts outputOptions) print(p printer) error {
+	mode, err := opts.mode()
+	if err != nil {
+		return err
+	}
+	return opts.printTo(os.Stdout, mode, p)
+}
+
+func (opts outputOptions) printTo(w io.Writer, mode string, p printer) error {
+	switch mode {
+	case outputTable:
+		p.table()
+		return nil
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p.value)
+	case outputYAML:
+		return yaml.NewEncoder(w).Encode(p.value)
+	case outputCSV:
+		out := csv.NewWriter(w)
+		if err := out.Write(p.header); err != nil {
+			return err
+		}
+		return out.WriteAll(p.rows)
+	case outputTemplate:
+		tmpl, err := template.New("format").Parse(opts.format)
+		if err != nil {
+			return err
+		}
+		for _, item := range p.items {
+			if err := tmpl.Execute(w, item); err != nil {
+				return err
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	default:
+		lines := p.plain
+		if lines == nil {
+			for _, row := range p.rows {
+				lines = append(lines, strings.Join(row, "\t"))
+			}
+		}
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+		return nil
+	}
+}
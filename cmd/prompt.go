@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/KonstantinGasser/sherlock/security"
+	"github.com/KonstantinGasser/sherlock/terminal"
+)
+
+// maxConfirmAttempts is the number of times a new password
+// can be entered before giving up on a mismatch
+const maxConfirmAttempts = 3
+
+var (
+	ErrPasswordMismatch = fmt.Errorf("passwords do not match")
+)
+
+// readNewPassword prompts for a new password. Unless noConfirm is set the
+// password has to be entered twice. Strength feedback against minEntropy is
+// shown before the confirmation so a weak password can be changed right away
+func readNewPassword(query, prompt string, minEntropy float64, noConfirm bool) (string, error) {
+	for attempt := 1; attempt <= maxConfirmAttempts; attempt++ {
+		password, err := terminal.ReadPassword("(%s) %s: ", query, prompt)
+		if err != nil {
+			return "", err
+		}
+		showStrength(password, minEntropy)
+		if noConfirm {
+			return password, nil
+		}
+		confirm, err := terminal.ReadPassword("(%s) repeat %s: ", query, prompt)
+		if err != nil {
+			return "", err
+		}
+		if confirm == password {
+			return password, nil
+		}
+		if attempt < maxConfirmAttempts {
+			terminal.Error("%v, please try again", ErrPasswordMismatch)
+		}
+	}
+	return "", ErrPasswordMismatch
+}
+
+// showStrength prints the entropy of the password and for
+// weak passwords its weaknesses with suggestions
+func showStrength(password string, minEntropy float64) {
+	strength := security.MeasureStrength(password)
+	if strength.Secure(minEntropy) {
+		terminal.Success("password strength ok (~%.0f bits, %.0f required)", strength.Entropy, minEntropy)
+		return
+	}
+	terminal.Warning("weak password (~%.0f bits, %.0f required)", strength.Entropy, minEntropy)
+	for i, weakness := range strength.Weaknesses {
+		terminal.Info("%s: %s", weakness, strength.Suggestions[i])
+	}
+}
@@ -2,18 +2,40 @@ package cmd
 
 import (
 	"context"
+	"path/filepath"
+	"time"
 
+	"github.com/KonstantinGasser/sherlock/agent"
+	"github.com/KonstantinGasser/sherlock/fs"
 	"github.com/KonstantinGasser/sherlock/internal"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
-const (
-	skippSetupFor = "setup"
-)
+// skippSetupFor lists the commands which do not require
+// sherlock to be set-up
+var skippSetupFor = map[string]bool{
+	"setup":           true,
+	"generate":        true,
+	clipboardClearCmd: true,
+	"agent":           true,
+	"lock":            true,
+}
 
-func RootCmd(sherlock *internal.Sherlock) *cobra.Command {
+// RootCmd returns the sherlock command. The file system for the configured
+// vault directory is created through newFileSystem before any command runs
+func RootCmd(newFileSystem func(root string) internal.FileSystem) *cobra.Command {
 
 	ctx := context.Background()
+	// sherlock is initialized once the configuration is loaded. Commands
+	// share the instance through the pointer
+	sherlock := new(internal.Sherlock)
+	// fileSystem is the file system sherlock was created with. doctor uses
+	// it for checks which are not part of internal.FileSystem
+	fileSystem := new(internal.FileSystem)
+	keyring := new(groupKeys)
+	var cfg *viper.Viper
+	var keyOpts keyOptions
 
 	root := &cobra.Command{
 		Use:           "sherlock",
@@ -24,22 +46,62 @@ func RootCmd(sherlock *internal.Sherlock) *cobra.Command {
 		// ensure that sherlock is properly set-up. This means that the default group
 		// exists and that it holds an encrypted .vault file. "sherlock setup" is excluded from this check
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			if cmd.Use == skippSetupFor {
+			if err := readConfig(cfg); err != nil {
+				return err
+			}
+			dir, err := filepath.Abs(vaultDir(cfg))
+			if err != nil {
+				return err
+			}
+			*fileSystem = newFileSystem(dir)
+			*sherlock = *internal.NewSherlock(*fileSystem)
+			sherlock.SetLockTimeout(cfg.GetDuration(configLockTimeout))
+			provider, err := newKeyProvider(keyOpts, cfg)
+			if err != nil {
+				return err
+			}
+			keyring.provider = provider
+			keyring.agent = agent.NewClient(cfg.GetString(configAgentSocket))
+			keyring.root = dir
+			keyring.verify = func(gid, key string) error {
+				_, err := sherlock.LoadGroup(gid, key)
+				return err
+			}
+			if skippSetupFor[cmd.Use] {
 				return nil
 			}
 			return sherlock.IsSetUp()
 		},
-		Run: func(cmd *cobra.Command, args []string) {
-			_ = cmd.Help()
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
 		},
 	}
 
-	root.AddCommand(cmdSetup(ctx, sherlock))
-	root.AddCommand(cmdAdd(ctx, sherlock))
-	root.AddCommand(cmdDel(ctx, sherlock))
-	root.AddCommand(cmdList(ctx, sherlock))
-	root.AddCommand(cmdGet(ctx, sherlock))
-	root.AddCommand(cmdUpdate(ctx, sherlock))
+	root.PersistentFlags().String(configVaultDir, fs.DefaultRoot(), "directory holding all sherlock groups (env: SHERLOCK_HOME)")
+	root.PersistentFlags().Duration(configLockTimeout, 5*time.Second, "time to wait for a group locked by another sherlock process")
+	cfg = newConfig(root.PersistentFlags())
+	// key flags are bound after the configuration so they can
+	// only be set on the command line
+	bindKeyFlags(root.PersistentFlags(), &keyOpts)
+
+	root.AddCommand(cmdSetup(ctx, sherlock, keyring))
+	root.AddCommand(cmdAdd(ctx, sherlock, keyring, cfg))
+	root.AddCommand(cmdDel(ctx, sherlock, keyring))
+	root.AddCommand(cmdList(ctx, sherlock, keyring))
+	root.AddCommand(cmdGet(ctx, sherlock, keyring, cfg))
+	root.AddCommand(cmdUpdate(ctx, sherlock, keyring, cfg))
+	root.AddCommand(cmdMigrate(ctx, sherlock, keyring))
+	root.AddCommand(cmdDoctor(ctx, sherlock, fileSystem))
+	root.AddCommand(cmdExec(ctx, sherlock, keyring))
+	root.AddCommand(cmdInject(ctx, sherlock, keyring))
+	root.AddCommand(cmdAudit(ctx, sherlock, keyring, cfg))
+	root.AddCommand(cmdRotate(ctx, sherlock, keyring, cfg))
+	root.AddCommand(cmdGenerate(ctx, cfg))
+	root.AddCommand(cmdAgent(ctx, cfg))
+	root.AddCommand(cmdUnlock(ctx, sherlock, keyring))
+	root.AddCommand(cmdLock(ctx, keyring))
 	root.AddCommand(cmdVersion())
+	root.AddCommand(cmdClipboardClear())
+	retryStaleKeys(root, keyring)
 	return root
 }
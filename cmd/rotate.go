@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/KonstantinGasser/sherlock/internal"
+	"github.com/KonstantinGasser/sherlock/security"
+	"github.com/KonstantinGasser/sherlock/terminal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// reasonStopped is the reason of accounts skipped since
+// the user stopped the rotation
+const reasonStopped = "rotation stopped"
+
+var (
+	ErrNothingToRotate = fmt.Errorf("no account to rotate")
+)
+
+type rotateOptions struct {
+	expired  bool
+	expiring bool
+	tag      string
+	insecure bool
+	show     bool
+	generatorOptions
+}
+
+// rotation holds the state shared by the accounts of a group rotation
+type rotation struct {
+	sherlock   *internal.Sherlock
+	cfg        *viper.Viper
+	opts       rotateOptions
+	groupKey   string
+	minEntropy float64
+}
+
+// skippedAccount is an account not rotated with the reason why
+type skippedAccount struct {
+	query  string
+	reason string
+}
+
+func cmdRotate(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys, cfg *viper.Viper) *cobra.Command {
+	var opts rotateOptions
+	rotate := &cobra.Command{
+		Use:   "rotate",
+		Short: "guided password rotation of the accounts of a group",
+		Long:  "rotate steps through the accounts of a group (or a single group@account). For each account a new password is generated and copied to the clipboard. Change the password on the remote site and confirm once it was accepted, only then the new password is stored. The previous password stays available as group@account/previous_password. Skipped accounts are reported at the end",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			gid, name := args[0], ""
+			if strings.Contains(args[0], "@") {
+				var err error
+				if gid, name, err = internal.SplitQuery(args[0]); err != nil {
+					return err
+				}
+			}
+			// validate the generator flags before asking for any password
+			if _, err := opts.generatorOptions.policy(); err != nil {
+				return err
+			}
+			groupKey, err := keyring.read(gid, "password")
+			if err != nil {
+				return err
+			}
+			group, err := sherlock.LoadGroup(gid, groupKey)
+			if err != nil {
+				return err
+			}
+			var accounts []internal.AccountInfo
+			for _, info := range group.Info(false, internal.FilterByTag(opts.tag)) {
+				if opts.selects(info, name) {
+					accounts = append(accounts, info)
+				}
+			}
+			if len(accounts) == 0 {
+				return ErrNothingToRotate
+			}
+
+			r := rotation{
+				sherlock:   sherlock,
+				cfg:        cfg,
+				opts:       opts,
+				groupKey:   groupKey,
+				minEntropy: group.RequiredEntropy(),
+			}
+			var rotated int
+			var skipped []skippedAccount
+			for i, info := range accounts {
+				query := info.Group + "@" + info.Name
+				reason, quit := r.account(ctx, query, info)
+				if reason == "" {
+					rotated++
+					continue
+				}
+				skipped = append(skipped, skippedAccount{query: query, reason: reason})
+				if quit {
+					for _, left := range accounts[i+1:] {
+						skipped = append(skipped, skippedAccount{query: left.Group + "@" + left.Name, reason: reasonStopped})
+					}
+					break
+				}
+			}
+
+			if rotated > 0 {
+				terminal.Success("rotated %d account(s), the previous passwords are available as group@account/previous_password", rotated)
+			}
+			if len(skipped) > 0 {
+				terminal.Warning("skipped %d account(s)", len(skipped))
+				var rows [][]string
+				for _, s := range skipped {
+					rows = append(rows, []string{s.query, s.reason})
+				}
+				terminal.ToTable([]string{"Account", "Reason"}, rows)
+			}
+			return nil
+		},
+	}
+	rotate.Flags().BoolVar(&opts.expired, "expired", false, "rotate only expired passwords")
+	rotate.Flags().BoolVar(&opts.expiring, "expiring", false, "rotate only expired passwords and passwords expiring soon")
+	rotate.Flags().StringVarP(&opts.tag, "tag", "t", "", "rotate only accounts with the tag")
+	rotate.Flags().BoolVarP(&opts.insecure, "insecure", "i", false, "allow generated passwords weaker than the group requires")
+	rotate.Flags().BoolVar(&opts.show, "print", false, "print the new passwords instead of copying them to the clipboard")
+	bindGeneratorFlags(rotate.Flags(), &opts.generatorOptions)
+	return rotate
+}
+
+// selects reports whether the account is rotated. Without --expired
+// or --expiring all accounts (or the given account) are rotated
+func (opts rotateOptions) selects(info internal.AccountInfo, name string) bool {
+	if name != "" && info.Name != name {
+		return false
+	}
+	switch state := info.Expiration.State; {
+	case opts.expiring:
+		return state == internal.ExpirationExpired || state == internal.ExpirationExpiring
+	case opts.expired:
+		return state == internal.ExpirationExpired
+	}
+	return true
+}
+
+// account generates a new password for the account and stores it once the
+// user confirms that the remote site accepted it. It returns why the account
+// was skipped (empty if rotated) and whether the user stopped the rotation
+func (r rotation) account(ctx context.Context, query string, info internal.AccountInfo) (string, bool) {
+	terminal.Info("(%s) %s", query, info.Expiration)
+	if info.URL != "" {
+		terminal.Info("(%s) change the password at %s", query, info.URL)
+	}
+	password, err := generatePassword(r.opts.generatorOptions)
+	if err != nil {
+		return err.Error(), false
+	}
+	// the password must be storable before it is changed on the remote site
+	if err := security.PasswordStrength(password, r.minEntropy); err != nil && !r.opts.insecure {
+		return fmt.Sprintf("generated %v (use --len)", err), false
+	}
+	share := func() error {
+		if r.opts.show {
+			terminal.Info("(%s) new password: %s", query, password)
+			return nil
+		}
+		if err := copyToClipboard(r.cfg, password, false); err != nil {
+			return err
+		}
+		terminal.Success("(%s) new password copied to clipboard", query)
+		return nil
+	}
+	if err := share(); err != nil {
+		return fmt.Sprintf("%v (use --print)", err), false
+	}
+
+	for {
+		answer, err := terminal.ReadLine("(%s) did the remote site accept the new password? [y]es, [c]opy again, [s]kip, [q]uit: ", query)
+		if err != nil {
+			return reasonStopped, true
+		}
+		switch strings.TrimSpace(answer) {
+		case "y":
+			err := r.sherlock.UpdateState(ctx, query, r.groupKey, internal.OptAccPassword(password, r.opts.insecure))
+			if errors.Is(err, internal.ErrPasswordUnchanged) {
+				// nothing changed on the remote site either
+				return err.Error(), false
+			}
+			if err != nil {
+				// the remote site already uses the new password
+				// so it must not get lost
+				terminal.Error("(%s) new password not stored: %v", query, err)
+				terminal.Warning("(%s) store it with update password: %s", query, password)
+				return "new password not stored: " + err.Error(), false
+			}
+			terminal.Success("(%s) password rotated", query)
+			return "", false
+		case "c":
+			if err := share(); err != nil {
+				terminal.Error(err.Error())
+			}
+		case "s":
+			return "skipped", false
+		case "q":
+			return reasonStopped, true
+		}
+	}
+}
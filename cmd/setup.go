@@ -2,35 +2,43 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/KonstantinGasser/sherlock/internal"
+	"github.com/KonstantinGasser/sherlock/security"
 	"github.com/KonstantinGasser/sherlock/terminal"
 	"github.com/spf13/cobra"
 )
 
-func cmdSetup(ctx context.Context, sherlock *internal.Sherlock) *cobra.Command {
-	return &cobra.Command{
+type setupOptions struct {
+	noConfirm bool
+}
+
+func cmdSetup(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
+	var opts setupOptions
+	setup := &cobra.Command{
 		Use:   "setup",
 		Short: "setup allows to initially set-up a main password for your vault",
 		Long:  "to encrypt and decrypt your vault you will need to set-up a main password",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := sherlock.IsSetUp(); err == nil {
-				terminal.Error("sherlock is already set-up")
-				return
+				return fmt.Errorf("sherlock is already set-up")
 			}
 			terminal.Success("sherlock has a default group for accounts not mapped to any group.\nPlease provide a group password for the default group.")
 
-			groupKey, err := terminal.ReadPassword("(default) group password: ")
+			groupKey, err := keyring.readNew("default", "group password", security.DefaultMinEntropy, opts.noConfirm)
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 
 			if err := sherlock.Setup(groupKey); err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 			terminal.Banner()
+			return nil
 		},
 	}
+	setup.Flags().BoolVar(&opts.noConfirm, "no-confirm", false, "do not ask to repeat the group password")
+
+	return setup
 }
@@ -2,83 +2,284 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"github.com/KonstantinGasser/sherlock/internal"
 	"github.com/KonstantinGasser/sherlock/terminal"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
-func cmdUpdate(ctx context.Context, sherlock *internal.Sherlock) *cobra.Command {
+func cmdUpdate(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys, cfg *viper.Viper) *cobra.Command {
 	update := &cobra.Command{
 		Use:   "update",
-		Short: "update an accounts password or name",
-		Long:  "update an accounts password or name",
+		Short: "update an accounts password or name or a group key",
+		Long:  "update an accounts password or name or a group key",
 		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
-			_ = cmd.Help()
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
 		},
 	}
-	update.AddCommand(cmdUpdateAccPassword(ctx, sherlock))
-	update.AddCommand(cmdUpdateAccName(ctx, sherlock))
+	update.AddCommand(cmdUpdateAccPassword(ctx, sherlock, keyring, cfg))
+	update.AddCommand(cmdUpdateAccName(ctx, sherlock, keyring))
+	update.AddCommand(cmdUpdateGroupKey(ctx, sherlock, keyring))
+	update.AddCommand(cmdUpdateAccField(ctx, sherlock, keyring))
+	update.AddCommand(cmdUpdatePolicy(ctx, sherlock, keyring))
 	return update
 }
 
 type passwordOptions struct {
-	insecure bool
+	insecure  bool
+	generate  bool
+	noConfirm bool
+	generatorOptions
 }
 
-func cmdUpdateAccPassword(ctx context.Context, sherlock *internal.Sherlock) *cobra.Command {
+func cmdUpdateAccPassword(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys, cfg *viper.Viper) *cobra.Command {
 	var opts passwordOptions
 	password := &cobra.Command{
 		Use:   "password",
 		Short: "change account password",
 		Long:  "allows to change/update the password of an existing account",
 		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
-			groupKey, err := terminal.ReadPassword("(%s) password: ", args[0])
+		RunE: func(cmd *cobra.Command, args []string) error {
+			groupKey, err := keyring.read(args[0], "password")
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
-			password, err := terminal.ReadPassword("(%s) new password: ", args[0])
+			gid, _, err := internal.SplitQuery(args[0])
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
+			}
+			group, err := sherlock.LoadGroup(gid, groupKey)
+			if err != nil {
+				return err
+			}
+			var password string
+			if opts.generate {
+				password, err = generatePassword(opts.generatorOptions)
+			} else {
+				password, err = readNewPassword(args[0], "new password", group.RequiredEntropy(), opts.noConfirm)
+			}
+			if err != nil {
+				return err
+			}
+			if !opts.generate {
+				warnBreached(cfg, password)
 			}
 			if err := sherlock.UpdateState(ctx, args[0], groupKey, internal.OptAccPassword(password, opts.insecure)); err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 			terminal.Info("account password updated")
+			return nil
 		},
 	}
 	password.Flags().BoolVarP(&opts.insecure, "insecure", "i", false, "allow insecure password for account")
+	password.Flags().BoolVarP(&opts.generate, "gen-password", "p", false, "auto-generate account password")
+	password.Flags().BoolVar(&opts.noConfirm, "no-confirm", false, "do not ask to repeat the new password")
+	bindGeneratorFlags(password.Flags(), &opts.generatorOptions)
 	return password
 }
 
-func cmdUpdateAccName(ctx context.Context, sherlock *internal.Sherlock) *cobra.Command {
+func cmdUpdateAccName(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
 	name := &cobra.Command{
 		Use:   "name",
 		Short: "change account name",
 		Long:  "allows to change/update the account of an existing account",
 		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
-			groupKey, err := terminal.ReadPassword("(%s) password: ", args[0])
+		RunE: func(cmd *cobra.Command, args []string) error {
+			groupKey, err := keyring.read(args[0], "password")
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 			name, err := terminal.ReadLine("(%s) new account name: ", args[0])
 			if err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 			if err := sherlock.UpdateState(ctx, args[0], groupKey, internal.OptAccName(name)); err != nil {
-				terminal.Error(err.Error())
-				return
+				return err
 			}
 			terminal.Info("account name updated")
+			return nil
 		},
 	}
 	return name
 }
+
+type groupKeyOptions struct {
+	insecure  bool
+	noConfirm bool
+}
+
+func cmdUpdateGroupKey(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
+	var opts groupKeyOptions
+	groupKey := &cobra.Command{
+		Use:   "group-key",
+		Short: "change a group password",
+		Long:  "allows to change the password of a group. All accounts of the group are re-encrypted with the new password",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// changing the key requires knowing it, a key
+			// cached by the agent is not enough
+			groupKey, err := keyring.readUncached(args[0], "password")
+			if err != nil {
+				return err
+			}
+			// verify the current key before asking for the new one
+			group, err := sherlock.LoadGroup(args[0], groupKey)
+			if err != nil {
+				return err
+			}
+			newGroupKey, err := readNewPassword(args[0], "new password", group.RequiredEntropy(), opts.noConfirm)
+			if err != nil {
+				return err
+			}
+			if err := sherlock.ChangeGroupKey(ctx, args[0], groupKey, newGroupKey, opts.insecure); err != nil {
+				return err
+			}
+			keyring.forget(args[0])
+			terminal.Success("group password for %q updated", args[0])
+			return nil
+		},
+	}
+	groupKey.Flags().BoolVarP(&opts.insecure, "insecure", "i", false, "allow insecure group password")
+	groupKey.Flags().BoolVar(&opts.noConfirm, "no-confirm", false, "do not ask to repeat the new group password")
+	return groupKey
+}
+
+type fieldOptions struct {
+	secret bool
+	delete bool
+}
+
+func cmdUpdateAccField(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
+	var opts fieldOptions
+	field := &cobra.Command{
+		Use:   "field",
+		Short: "set or delete an account field",
+		Long:  "allows to set (key=value) or delete (key) a standard (username, url, notes) or custom field of an existing account",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, value, err := internal.ParseField(args[1])
+			if err != nil {
+				return err
+			}
+			groupKey, err := keyring.read(args[0], "password")
+			if err != nil {
+				return err
+			}
+
+			opt := internal.OptAccField(key, value, opts.secret)
+			switch {
+			case opts.delete:
+				opt = internal.OptAccDeleteField(key)
+			case opts.secret && value == "":
+				value, err = terminal.ReadPassword("(%s) %s: ", args[0], key)
+				if err != nil {
+					return err
+				}
+				opt = internal.OptAccField(key, value, opts.secret)
+			}
+			if err := sherlock.UpdateState(ctx, args[0], groupKey, opt); err != nil {
+				return err
+			}
+			terminal.Info("account field %q updated", key)
+			return nil
+		},
+	}
+	field.Flags().BoolVarP(&opts.secret, "secret", "s", false, "mark the field as secret (prompts for the value if omitted)")
+	field.Flags().BoolVarP(&opts.delete, "delete", "d", false, "delete the field")
+	return field
+}
+
+type policyOptions struct {
+	minEntropy  float64
+	rotateAfter string
+	tag         string
+}
+
+// policyDefault resets a rotation policy to the one of the tag,
+// group or internal.DefaultRotation
+const policyDefault = "default"
+
+var (
+	ErrNoPolicyChanged = fmt.Errorf("no policy changed (use --min-entropy or --rotate-after)")
+	ErrGroupPolicy     = fmt.Errorf("--min-entropy and --tag can only be set for a group")
+)
+
+func cmdUpdatePolicy(ctx context.Context, sherlock *internal.Sherlock, keyring *groupKeys) *cobra.Command {
+	var opts policyOptions
+	policy := &cobra.Command{
+		Use:   "policy",
+		Short: "change the password policy of a group, tag or account",
+		Long:  "allows to change the minimum entropy in bits the group password and the account passwords of a group need and the rotation policy after which passwords expire. Rotation policies are set for a group, for the accounts of a group with a tag (--tag) or for a single account (group@account). The policy of an account wins over the one of its tag which wins over the one of the group. Existing passwords are not checked again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			gid, account := args[0], ""
+			if strings.Contains(args[0], "@") {
+				var err error
+				if gid, account, err = internal.SplitQuery(args[0]); err != nil {
+					return err
+				}
+			}
+			tag := strings.TrimPrefix(opts.tag, "#")
+			if account != "" && (cmd.Flags().Changed("min-entropy") || tag != "") {
+				return ErrGroupPolicy
+			}
+
+			var stateOpts []internal.StateOption
+			if cmd.Flags().Changed("min-entropy") {
+				stateOpts = append(stateOpts, internal.OptMinEntropy(opts.minEntropy))
+			}
+			if cmd.Flags().Changed("rotate-after") {
+				period, err := parsePolicy(opts.rotateAfter)
+				if err != nil {
+					return err
+				}
+				switch {
+				case account != "":
+					stateOpts = append(stateOpts, internal.OptAccRotation(period))
+				case tag != "":
+					stateOpts = append(stateOpts, internal.OptTagRotation(tag, period))
+				default:
+					stateOpts = append(stateOpts, internal.OptRotation(period))
+				}
+			}
+			if len(stateOpts) == 0 {
+				return ErrNoPolicyChanged
+			}
+			groupKey, err := keyring.read(gid, "password")
+			if err != nil {
+				return err
+			}
+			if account != "" {
+				err = sherlock.UpdateState(ctx, args[0], groupKey, stateOpts[0])
+			} else {
+				err = sherlock.UpdateGroup(ctx, gid, groupKey, stateOpts...)
+			}
+			if err != nil {
+				return err
+			}
+			terminal.Success("password policy of %q updated", args[0])
+			return nil
+		},
+	}
+	policy.Flags().Float64Var(&opts.minEntropy, "min-entropy", 0, "minimum entropy in bits of passwords of the group (0 resets to the default of 60)")
+	policy.Flags().StringVar(&opts.rotateAfter, "rotate-after", "", "period after which passwords expire like 30d, 2w, 6m, 1y or never (default removes the policy)")
+	policy.Flags().StringVarP(&opts.tag, "tag", "t", "", "set the rotation policy of the accounts with the tag")
+	return policy
+}
+
+// parsePolicy parses a rotation period. policyDefault
+// returns nil to remove a rotation policy
+func parsePolicy(s string) (*internal.Period, error) {
+	if s == policyDefault {
+		return nil, nil
+	}
+	period, err := internal.ParsePeriod(s)
+	if err != nil {
+		return nil, err
+	}
+	return &period, nil
+}
@@ -13,8 +13,9 @@ func cmdVersion() *cobra.Command {
 		Short: "display sherlock version",
 		Long:  "display sherlock version",
 		Args:  cobra.ExactArgs(0),
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			terminal.Version(Version)
+			return nil
 		},
 	}
 }
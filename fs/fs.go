@@ -1,12 +1,13 @@
 package fs
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/afero"
 )
@@ -16,69 +17,80 @@ const (
 	groupsDir     = "groups"
 	defaultGroup  = "default"
 	vaultFileName = ".vault"
+	kdfFileName   = "kdf.json"
+	// backupSuffix is appended to the file name of the previous
+	// version of a file replaced by writeAtomic
+	backupSuffix = ".bak"
+	// tempSuffix is appended to the file name of the temp file
+	// used by writeAtomic
+	tempSuffix = ".tmp-"
+	// lockFileName is the advisory lock file of a group. It holds
+	// the pid of the process owning the lock
+	lockFileName = ".vault.lock"
+	// lockRetryInterval is the time waited before trying to acquire
+	// a lock held by another process again
+	lockRetryInterval = 50 * time.Millisecond
+	// lockBreakSuffix is appended to the lock file for the file held by
+	// the process removing a stale lock
+	lockBreakSuffix = ".break"
+	// staleBreakAge is the age after which a break file is considered
+	// left behind by a crashed process
+	staleBreakAge = 10 * time.Second
+
+	// dirPerm is the permission of every directory in the sherlock root
+	dirPerm os.FileMode = 0700
+	// filePerm is the permission of every file in the sherlock root
+	filePerm os.FileMode = 0600
 )
 
 var (
 	ErrNoSuchGroup = fmt.Errorf("group not found in sherlock")
 	ErrNoSuchVault = fmt.Errorf("vault for group not found in sherlock")
 	ErrGroupExists = fmt.Errorf("group already exists")
+	ErrGroupLocked = fmt.Errorf("group is locked")
 )
 
 type Fs struct {
 	mock afero.Fs
+	// root is the directory holding all sherlock files
+	// (see DefaultRoot)
+	root string
 }
 
-func New(mock afero.Fs) *Fs {
+// New returns a new Fs storing all sherlock files in the root directory
+func New(mock afero.Fs, root string) *Fs {
 	return &Fs{
 		mock: mock,
+		root: root,
 	}
 }
 
 // ReadVault reads the stored .vault file
 func (fs Fs) ReadGroupVault(group string) ([]byte, error) {
-	return afero.ReadFile(fs.mock, buildVaultPath(group))
+	return afero.ReadFile(fs.mock, fs.buildVaultPath(group))
 }
 
 // InitFs creates all directories required to be setup to use
 // sherlock. If the directory exists nothing happens
 func (fs Fs) InitFs(initVault []byte) error {
-	if err := fs.mock.MkdirAll(filepath.Join(homepath(), sherlockRoot, groupsDir, defaultGroup), 0777); err != nil {
-		return err
-	}
-
-	f, err := fs.mock.OpenFile(buildVaultPath(defaultGroup), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0777)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	if _, err := io.Copy(f, bytes.NewReader(initVault)); err != nil {
+	if err := fs.mock.MkdirAll(fs.buildGroupPath(defaultGroup), dirPerm); err != nil {
 		return err
 	}
-	return nil
+	return fs.writeAtomic(fs.buildVaultPath(defaultGroup), initVault)
 }
 
 // CreateGroup creates a new directory for a given group with its .vault file.
 // if the group already exists it will be overwritten! To check if a group exists you should use the
 // fs.GroupExists func
 func (fs Fs) CreateGroup(name string, initVault []byte) error {
-	if err := fs.mock.MkdirAll(filepath.Join(homepath(), sherlockRoot, groupsDir, name), 0777); err != nil {
+	if err := fs.mock.MkdirAll(fs.buildGroupPath(name), dirPerm); err != nil {
 		return err
 	}
-	f, err := fs.mock.OpenFile(buildVaultPath(name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0777)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	if _, err := io.Copy(f, bytes.NewReader(initVault)); err != nil {
-		return err
-	}
-	return nil
+	return fs.writeAtomic(fs.buildVaultPath(name), initVault)
 }
 
 func (fs Fs) GroupExists(name string) error {
-	_, err := fs.mock.Stat(buildGroupPath(name))
+	_, err := fs.mock.Stat(fs.buildGroupPath(name))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -89,7 +101,7 @@ func (fs Fs) GroupExists(name string) error {
 }
 
 func (fs Fs) VaultExists(group string) error {
-	_, err := fs.mock.Stat(buildVaultPath(group))
+	_, err := fs.mock.Stat(fs.buildVaultPath(group))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -101,34 +113,276 @@ func (fs Fs) VaultExists(group string) error {
 
 // Delete removes the passed in group directory irreversible from sherlock
 func (fs Fs) Delete(ctx context.Context, gid string) error {
-	return fs.mock.RemoveAll(buildGroupPath(gid))
+	return fs.mock.RemoveAll(fs.buildGroupPath(gid))
 }
 
+// Write replaces the .vault file of a group. The previous vault
+// is kept as .vault.bak
 func (fs Fs) Write(ctx context.Context, gid string, data []byte) error {
-	if err := afero.WriteFile(fs.mock, buildVaultPath(gid), data, os.ModeAppend); err != nil {
+	return fs.writeAtomic(fs.buildVaultPath(gid), data)
+}
+
+// RemoveBackup removes the .vault.bak of a group kept by Write. A group
+// without a backup is left as it is
+func (fs Fs) RemoveBackup(gid string) error {
+	err := fs.mock.Remove(fs.buildVaultPath(gid) + backupSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fs.syncDir(fs.buildGroupPath(gid))
+	return nil
+}
+
+// writeAtomic replaces the file at path without ever leaving a partially
+// written file behind: the data is written to a temp file in the same directory,
+// synced to disk and then renamed over path. If path already exists its content
+// is kept as path.bak before it gets replaced
+func (fs Fs) writeAtomic(path string, data []byte) error {
+	current, err := afero.ReadFile(fs.mock, path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := fs.replaceFile(path+backupSuffix, current); err != nil {
+			return err
+		}
+	}
+	return fs.replaceFile(path, data)
+}
+
+// replaceFile writes data to a temp file next to path and renames it over path
+func (fs Fs) replaceFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := afero.TempFile(fs.mock, dir, filepath.Base(path)+tempSuffix)
+	if err != nil {
+		return err
+	}
+	if err := writeAndSync(tmp, data); err != nil {
+		_ = fs.mock.Remove(tmp.Name())
+		return err
+	}
+	if err := fs.mock.Chmod(tmp.Name(), filePerm); err != nil {
+		_ = fs.mock.Remove(tmp.Name())
 		return err
 	}
+	if err := fs.mock.Rename(tmp.Name(), path); err != nil {
+		_ = fs.mock.Remove(tmp.Name())
+		return err
+	}
+	fs.syncDir(dir)
 	return nil
 }
 
-func buildGroupPath(gid string) string {
-	return filepath.Join(homepath(), sherlockRoot, groupsDir, gid)
+// writeAndSync writes data to f, flushes it to disk and closes f
+func writeAndSync(f afero.File, data []byte) error {
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir flushes the directory entry of a renamed file to disk. Not all
+// file systems support syncing a directory, hence errors are ignored
+func (fs Fs) syncDir(dir string) {
+	d, err := fs.mock.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+// ReadKDFParams reads the stored key derivation parameters. If none
+// have been stored it returns nil
+func (fs Fs) ReadKDFParams() ([]byte, error) {
+	data, err := afero.ReadFile(fs.mock, fs.buildKDFPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// WriteKDFParams stores the key derivation parameters in the sherlock root
+func (fs Fs) WriteKDFParams(data []byte) error {
+	if err := fs.mock.MkdirAll(fs.root, dirPerm); err != nil {
+		return err
+	}
+	return fs.replaceFile(fs.buildKDFPath(), data)
+}
+
+// Lock acquires the advisory lock of a group for the current process.
+//
+// If another process holds the lock Lock retries until the timeout passed, in
+// which case an error wrapping ErrGroupLocked with the pid of the owner is returned.
+// Locks left behind by processes which are no longer running are removed.
+func (fs Fs) Lock(ctx context.Context, gid string, timeout time.Duration) error {
+	if _, err := fs.mock.Stat(fs.buildGroupPath(gid)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoSuchGroup
+		}
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		acquired, err := fs.tryLock(gid)
+		if err != nil || acquired {
+			return err
+		}
+		pid, err := fs.lockOwner(gid)
+		if err != nil {
+			return err
+		}
+		if pid != 0 && !processAlive(pid) {
+			broken, err := fs.breakStaleLock(gid, pid)
+			if err != nil {
+				return err
+			}
+			if broken {
+				continue
+			}
+		}
+		if time.Now().After(deadline) {
+			if pid == 0 {
+				return fmt.Errorf("%w (remove %s if no other sherlock is running)", ErrGroupLocked, fs.buildLockPath(gid))
+			}
+			return fmt.Errorf("%w by pid %d", ErrGroupLocked, pid)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// breakStaleLock removes the lock of a group left behind by the dead process
+// pid. Processes waiting for the lock take turns through a break file so the
+// lock is re-read and only removed if it still belongs to pid. A waiter which
+// saw the dead pid too late never removes the fresh lock of another process.
+// It reports false if another process is breaking the lock
+func (fs Fs) breakStaleLock(gid string, pid int) (bool, error) {
+	breakPath := fs.buildLockPath(gid) + lockBreakSuffix
+	f, err := fs.mock.OpenFile(breakPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
+	if err != nil {
+		if !os.IsExist(err) {
+			return false, err
+		}
+		// the break file of a crashed process would block the group forever
+		if info, err := fs.mock.Stat(breakPath); err == nil && time.Since(info.ModTime()) > staleBreakAge {
+			_ = fs.mock.Remove(breakPath)
+		}
+		return false, nil
+	}
+	f.Close()
+	defer fs.mock.Remove(breakPath)
+
+	owner, err := fs.lockOwner(gid)
+	if err != nil {
+		return false, err
+	}
+	if owner != pid {
+		// released or taken over in the meantime
+		return true, nil
+	}
+	if err := fs.mock.Remove(fs.buildLockPath(gid)); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// tryLock creates the lock file of a group holding the pid of the
+// current process. It reports false if the lock file already exists
+func (fs Fs) tryLock(gid string) (bool, error) {
+	f, err := fs.mock.OpenFile(fs.buildLockPath(gid), os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
+	if err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := writeAndSync(f, []byte(strconv.Itoa(os.Getpid()))); err != nil {
+		_ = fs.mock.Remove(fs.buildLockPath(gid))
+		return false, err
+	}
+	return true, nil
+}
+
+// lockOwner reads the pid of the process owning the lock of a group. It
+// returns 0 if the owner is unknown since the lock has been released in the
+// meantime or its owner has not yet written its pid
+func (fs Fs) lockOwner(gid string) (int, error) {
+	data, err := afero.ReadFile(fs.mock, fs.buildLockPath(gid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, nil
+	}
+	return pid, nil
+}
+
+// Unlock releases the advisory lock of a group if it is held by
+// the current process
+func (fs Fs) Unlock(gid string) error {
+	data, err := afero.ReadFile(fs.mock, fs.buildLockPath(gid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	return fs.mock.Remove(fs.buildLockPath(gid))
+}
+
+// buildGroupPath creates a file path like
+// => {root}/groups/{group}
+func (fs Fs) buildGroupPath(gid string) string {
+	return filepath.Join(fs.root, groupsDir, gid)
 }
 
 // buildVaultPath creates a file path like
-// => $HOME/.sherlock/groups/{group}/.vault
-func buildVaultPath(gid string) string {
-	return filepath.Join(homepath(), sherlockRoot, groupsDir, gid, vaultFileName)
+// => {root}/groups/{group}/.vault
+func (fs Fs) buildVaultPath(gid string) string {
+	return filepath.Join(fs.root, groupsDir, gid, vaultFileName)
+}
+
+// buildLockPath creates a file path like
+// => {root}/groups/{group}/.vault.lock
+func (fs Fs) buildLockPath(gid string) string {
+	return filepath.Join(fs.root, groupsDir, gid, lockFileName)
+}
+
+// buildKDFPath creates a file path like
+// => {root}/kdf.json
+func (fs Fs) buildKDFPath() string {
+	return filepath.Join(fs.root, kdfFileName)
 }
 
-func homepath() string {
+// DefaultRoot returns the default sherlock root
+// => $HOME/.sherlock
+func DefaultRoot() string {
 	home, _ := os.UserHomeDir()
-	return home
+	return filepath.Join(home, sherlockRoot)
 }
 
 // Read All Groups Saved
 func (fs Fs) ReadRegisteredGroups() ([]string, error) {
-	groupList, err := afero.ReadDir(fs.mock, buildGroupPath(""))
+	groupList, err := afero.ReadDir(fs.mock, fs.buildGroupPath(""))
 	if err != nil {
 		return nil, err
 	}
@@ -3,14 +3,18 @@ package fs
 import (
 	"bytes"
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/spf13/afero"
 )
 
 var (
+	testRoot          = filepath.FromSlash("/home/sherlock/.sherlock")
 	defaultInitVault  = []byte("init-default-vault-content")
 	dummyWriteContent = []byte("this-is-just-some-dummy-content-for-io")
 )
@@ -20,6 +24,7 @@ var (
 func TestInitFs(t *testing.T) {
 	f := Fs{
 		mock: afero.NewMemMapFs(),
+		root: testRoot,
 	}
 
 	err := f.InitFs(defaultInitVault)
@@ -28,13 +33,13 @@ func TestInitFs(t *testing.T) {
 	}
 
 	// check if all exists
-	_, err = f.mock.Stat(filepath.Join(homepath(), sherlockRoot, groupsDir, defaultGroup))
+	_, err = f.mock.Stat(f.buildGroupPath(defaultGroup))
 	if err != nil {
 		if os.IsNotExist(err) {
 			t.Fatalf("fs.InitFs: default group dir not created")
 		}
 	}
-	defaultVault, err := afero.ReadFile(f.mock, buildVaultPath(defaultGroup))
+	defaultVault, err := afero.ReadFile(f.mock, f.buildVaultPath(defaultGroup))
 	if err != nil {
 		t.Fatalf("fs.InitFs: could not open default group vault: %v", err)
 	}
@@ -48,6 +53,7 @@ func TestCreateGroup(t *testing.T) {
 
 	f := Fs{
 		mock: afero.NewMemMapFs(),
+		root: testRoot,
 	}
 
 	err := f.CreateGroup(testGroup, defaultInitVault)
@@ -56,7 +62,7 @@ func TestCreateGroup(t *testing.T) {
 	}
 
 	// check if exists
-	vault, err := afero.ReadFile(f.mock, buildVaultPath(testGroup))
+	vault, err := afero.ReadFile(f.mock, f.buildVaultPath(testGroup))
 	if err != nil {
 		t.Fatalf("fs.CreateGroup: could not open test group vault: %v", err)
 	}
@@ -68,6 +74,7 @@ func TestCreateGroup(t *testing.T) {
 func TestWrite(t *testing.T) {
 	f := Fs{
 		mock: afero.NewMemMapFs(),
+		root: testRoot,
 	}
 
 	testGroup := "test-group"
@@ -82,7 +89,7 @@ func TestWrite(t *testing.T) {
 	}
 
 	// check it written
-	vault, err := afero.ReadFile(f.mock, buildVaultPath(testGroup))
+	vault, err := afero.ReadFile(f.mock, f.buildVaultPath(testGroup))
 	if err != nil {
 		t.Fatalf("fs.Write: could not open test group vault: %v", err)
 	}
@@ -91,3 +98,225 @@ func TestWrite(t *testing.T) {
 	}
 
 }
+
+func TestWriteKeepsBackup(t *testing.T) {
+	f := Fs{
+		mock: afero.NewMemMapFs(),
+		root: testRoot,
+	}
+
+	testGroup := "test-group"
+	if err := f.CreateGroup(testGroup, defaultInitVault); err != nil {
+		t.Fatalf("fs.CreateGroup: want: nil, have: %v", err)
+	}
+	if err := f.Write(context.Background(), testGroup, dummyWriteContent); err != nil {
+		t.Fatalf("fs.Write: want: nil, have: %v", err)
+	}
+
+	backup, err := afero.ReadFile(f.mock, f.buildVaultPath(testGroup)+backupSuffix)
+	if err != nil {
+		t.Fatalf("fs.Write: could not open vault backup: %v", err)
+	}
+	if ok := bytes.Compare(backup, defaultInitVault); ok != 0 {
+		t.Fatalf("fs.Write: backup: want: %s, have: %s", defaultInitVault, backup)
+	}
+	assertNoTempFiles(t, f, testGroup)
+}
+
+func TestRemoveBackup(t *testing.T) {
+	f := Fs{
+		mock: afero.NewMemMapFs(),
+		root: testRoot,
+	}
+
+	testGroup := "test-group"
+	if err := f.CreateGroup(testGroup, defaultInitVault); err != nil {
+		t.Fatalf("fs.CreateGroup: want: nil, have: %v", err)
+	}
+	if err := f.RemoveBackup(testGroup); err != nil {
+		t.Fatalf("fs.RemoveBackup: without backup: want: nil, have: %v", err)
+	}
+	if err := f.Write(context.Background(), testGroup, dummyWriteContent); err != nil {
+		t.Fatalf("fs.Write: want: nil, have: %v", err)
+	}
+	if err := f.RemoveBackup(testGroup); err != nil {
+		t.Fatalf("fs.RemoveBackup: want: nil, have: %v", err)
+	}
+	if ok, _ := afero.Exists(f.mock, f.buildVaultPath(testGroup)+backupSuffix); ok {
+		t.Fatalf("fs.RemoveBackup: want: no backup, have: backup")
+	}
+	vault, err := f.ReadGroupVault(testGroup)
+	if err != nil || !bytes.Equal(vault, dummyWriteContent) {
+		t.Fatalf("fs.RemoveBackup: want: vault %s, have: %s (err: %v)", dummyWriteContent, vault, err)
+	}
+}
+
+func TestWriteFailure(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	f := Fs{
+		mock: mem,
+		root: testRoot,
+	}
+
+	testGroup := "test-group"
+	if err := f.CreateGroup(testGroup, defaultInitVault); err != nil {
+		t.Fatalf("fs.CreateGroup: want: nil, have: %v", err)
+	}
+
+	// from now on every write to a file fails as if the disk is full
+	f.mock = fullDiskFs{mem}
+	if err := f.Write(context.Background(), testGroup, dummyWriteContent); err == nil {
+		t.Fatalf("fs.Write: want: error, have: nil")
+	}
+
+	vault, err := afero.ReadFile(f.mock, f.buildVaultPath(testGroup))
+	if err != nil {
+		t.Fatalf("fs.Write: could not open test group vault: %v", err)
+	}
+	if ok := bytes.Compare(vault, defaultInitVault); ok != 0 {
+		t.Fatalf("fs.Write: vault changed by failed write: want: %s, have: %s", defaultInitVault, vault)
+	}
+	assertNoTempFiles(t, f, testGroup)
+}
+
+// assertNoTempFiles fails the test if any temp file of writeAtomic
+// has been left in the group directory
+func assertNoTempFiles(t *testing.T, f Fs, gid string) {
+	files, err := afero.ReadDir(f.mock, f.buildGroupPath(gid))
+	if err != nil {
+		t.Fatalf("afero.ReadDir: %v", err)
+	}
+	for _, file := range files {
+		if strings.Contains(file.Name(), tempSuffix) {
+			t.Fatalf("fs.writeAtomic: temp file %q left behind", file.Name())
+		}
+	}
+}
+
+// fullDiskFs simulates a full disk: files can be created but
+// every write to them fails
+type fullDiskFs struct {
+	afero.Fs
+}
+
+func (fs fullDiskFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return fullDiskFile{f}, nil
+}
+
+type fullDiskFile struct {
+	afero.File
+}
+
+func (f fullDiskFile) Write(p []byte) (int, error) {
+	return 0, syscall.ENOSPC
+}
+
+func TestLock(t *testing.T) {
+	f := Fs{
+		mock: afero.NewMemMapFs(),
+		root: testRoot,
+	}
+	ctx := context.Background()
+
+	testGroup := "test-group"
+	if err := f.CreateGroup(testGroup, defaultInitVault); err != nil {
+		t.Fatalf("fs.CreateGroup: want: nil, have: %v", err)
+	}
+
+	if err := f.Lock(ctx, "no-such-group", 0); err != ErrNoSuchGroup {
+		t.Fatalf("fs.Lock: want: %v, have: %v", ErrNoSuchGroup, err)
+	}
+	if err := f.Lock(ctx, testGroup, 0); err != nil {
+		t.Fatalf("fs.Lock: want: nil, have: %v", err)
+	}
+	// the lock is held by this process, so a second lock must time out
+	if err := f.Lock(ctx, testGroup, 2*lockRetryInterval); !errors.Is(err, ErrGroupLocked) {
+		t.Fatalf("fs.Lock: want: %v, have: %v", ErrGroupLocked, err)
+	}
+	if err := f.Unlock(testGroup); err != nil {
+		t.Fatalf("fs.Unlock: want: nil, have: %v", err)
+	}
+	if err := f.Lock(ctx, testGroup, 0); err != nil {
+		t.Fatalf("fs.Lock: after unlock: want: nil, have: %v", err)
+	}
+	if err := f.Unlock(testGroup); err != nil {
+		t.Fatalf("fs.Unlock: want: nil, have: %v", err)
+	}
+}
+
+func TestLockStale(t *testing.T) {
+	f := Fs{
+		mock: afero.NewMemMapFs(),
+		root: testRoot,
+	}
+
+	testGroup := "test-group"
+	if err := f.CreateGroup(testGroup, defaultInitVault); err != nil {
+		t.Fatalf("fs.CreateGroup: want: nil, have: %v", err)
+	}
+	// pid of a process which is (almost certainly) not running
+	stalePid := []byte("2147483646")
+	if err := afero.WriteFile(f.mock, f.buildLockPath(testGroup), stalePid, 0600); err != nil {
+		t.Fatalf("afero.WriteFile: %v", err)
+	}
+	if err := f.Lock(context.Background(), testGroup, 0); err != nil {
+		t.Fatalf("fs.Lock: stale lock: want: nil, have: %v", err)
+	}
+	if err := f.Unlock(testGroup); err != nil {
+		t.Fatalf("fs.Unlock: want: nil, have: %v", err)
+	}
+
+	// a waiter which saw the dead pid after the lock was taken
+	// over must not remove the fresh lock
+	if err := f.Lock(context.Background(), testGroup, 0); err != nil {
+		t.Fatalf("fs.Lock: want: nil, have: %v", err)
+	}
+	if _, err := f.breakStaleLock(testGroup, 2147483646); err != nil {
+		t.Fatalf("fs.breakStaleLock: want: nil, have: %v", err)
+	}
+	if pid, _ := f.lockOwner(testGroup); pid != os.Getpid() {
+		t.Fatalf("fs.breakStaleLock: want: lock of pid %d kept, have: %d", os.Getpid(), pid)
+	}
+	if err := f.Unlock(testGroup); err != nil {
+		t.Fatalf("fs.Unlock: want: nil, have: %v", err)
+	}
+}
+
+func TestAuditPermissions(t *testing.T) {
+	f := Fs{
+		mock: afero.NewMemMapFs(),
+		root: testRoot,
+	}
+
+	testGroup := "test-group"
+	if err := f.CreateGroup(testGroup, defaultInitVault); err != nil {
+		t.Fatalf("fs.CreateGroup: want: nil, have: %v", err)
+	}
+	issues, err := f.AuditPermissions()
+	if err != nil || len(issues) != 0 {
+		t.Fatalf("fs.AuditPermissions: want: no issues, have: %v (err: %v)", issues, err)
+	}
+
+	if err := f.mock.Chmod(f.buildVaultPath(testGroup), 0644); err != nil {
+		t.Fatalf("afero.Chmod: %v", err)
+	}
+	if err := f.mock.Chmod(f.buildGroupPath(testGroup), 0755); err != nil {
+		t.Fatalf("afero.Chmod: %v", err)
+	}
+	issues, err = f.AuditPermissions()
+	if err != nil || len(issues) != 2 {
+		t.Fatalf("fs.AuditPermissions: want: 2 issues, have: %v (err: %v)", issues, err)
+	}
+
+	if err := f.FixPermissions(issues); err != nil {
+		t.Fatalf("fs.FixPermissions: want: nil, have: %v", err)
+	}
+	issues, err = f.AuditPermissions()
+	if err != nil || len(issues) != 0 {
+		t.Fatalf("fs.AuditPermissions: after fix: want: no issues, have: %v (err: %v)", issues, err)
+	}
+}
@@ -0,0 +1,76 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/afero"
+)
+
+// PermissionIssue describes a file or directory in the sherlock root
+// which is accessible by more than its owner or not owned by the current user
+type PermissionIssue struct {
+	Path    string
+	Mode    os.FileMode
+	Want    os.FileMode
+	Problem string
+	// Fixable is false if the issue cannot be resolved by sherlock
+	// (for instance an entry owned by another user)
+	Fixable bool
+}
+
+// AuditPermissions walks the sherlock root and reports every entry with
+// permissions exceeding 0700 (directories) and 0600 (files) or owned by
+// another user
+func (fs Fs) AuditPermissions() ([]PermissionIssue, error) {
+	var issues []PermissionIssue
+	err := afero.Walk(fs.mock, fs.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		want := filePerm
+		if info.IsDir() {
+			want = dirPerm
+		}
+		if uid, ok := fileOwner(info); ok && uid != os.Getuid() {
+			issues = append(issues, PermissionIssue{
+				Path:    path,
+				Mode:    info.Mode().Perm(),
+				Want:    want,
+				Problem: fmt.Sprintf("owned by uid %d", uid),
+				Fixable: false,
+			})
+		}
+		if info.Mode().Perm()&^want != 0 {
+			issues = append(issues, PermissionIssue{
+				Path:    path,
+				Mode:    info.Mode().Perm(),
+				Want:    want,
+				Problem: "accessible by other users",
+				Fixable: true,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return issues, nil
+}
+
+// FixPermissions restricts the permissions of all fixable issues
+// to the permissions sherlock expects
+func (fs Fs) FixPermissions(issues []PermissionIssue) error {
+	for _, issue := range issues {
+		if !issue.Fixable {
+			continue
+		}
+		if err := fs.mock.Chmod(issue.Path, issue.Want); err != nil {
+			return err
+		}
+	}
+	return nil
+}
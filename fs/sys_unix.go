@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// processAlive reports whether a process with the pid is running
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	// EPERM: the process exists but belongs to another user
+	return err == nil || err == syscall.EPERM
+}
+
+// fileOwner returns the uid of the owner of a file. It reports false if
+// the underlying file system does not provide an owner
+func fileOwner(info os.FileInfo) (int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(stat.Uid), true
+}
@@ -0,0 +1,20 @@
+package fs
+
+import "os"
+
+// processAlive reports whether a process with the pid is running. On
+// windows os.FindProcess fails for processes which do not exist
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
+
+// fileOwner is not supported on windows since files are protected
+// by ACLs rather than a single owner
+func fileOwner(info os.FileInfo) (int, bool) {
+	return 0, false
+}
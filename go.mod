@@ -15,4 +15,6 @@ require (
 	github.com/spf13/viper v1.9.0
 	github.com/wagslane/go-password-validator v0.3.0
 	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	golang.org/x/sys v0.0.0-20210823070655-63515b42dcdf
+	gopkg.in/yaml.v2 v2.4.0
 )
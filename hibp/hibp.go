@@ -0,0 +1,173 @@
+// Package hibp checks passwords against a local copy of the Pwned Passwords
+// SHA-1 list (https://haveibeenpwned.com/Passwords) ordered by hash. The file
+// is searched in place so dumps of several GB are never loaded into memory
+package hibp
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// hashLen is the length of a hex encoded SHA-1 hash
+	hashLen = 40
+	// chunkSize is the number of bytes read at once while
+	// looking for a line. Lines are ~45 bytes long
+	chunkSize = 128
+)
+
+var (
+	ErrInvalidDump = fmt.Errorf("not a Pwned Passwords SHA-1 file (want lines of HASH:COUNT ordered by hash)")
+)
+
+// Dump is an opened Pwned Passwords SHA-1 file
+type Dump struct {
+	file *os.File
+	size int64
+}
+
+// Open opens the Pwned Passwords file at path and checks that
+// its first line is in the format HASH:COUNT
+func Open(path string) (*Dump, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	d := &Dump{file: f, size: info.Size()}
+	_, line, err := d.lineFrom(0)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, _, err := parseLine(line); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Close closes the underlying file
+func (d *Dump) Close() error {
+	return d.file.Close()
+}
+
+// Count returns how often the password appears in the breaches
+// of the dump. Zero means the password is not part of the dump
+func (d *Dump) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	return d.CountHash(strings.ToUpper(hex.EncodeToString(sum[:])))
+}
+
+// CountHash returns the count of an upper case hex encoded SHA-1 hash.
+//
+// It binary searches the byte offsets of the file for the first line
+// with a hash not less than the hash, reading only a few bytes per step
+func (d *Dump) CountHash(hash string) (int, error) {
+	lo, hi := int64(0), d.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		_, line, err := d.lineFrom(mid)
+		if err != nil {
+			return 0, err
+		}
+		if line == nil {
+			hi = mid
+			continue
+		}
+		lineHash, _, err := parseLine(line)
+		if err != nil {
+			return 0, err
+		}
+		if lineHash < hash {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	_, line, err := d.lineFrom(lo)
+	if err != nil || line == nil {
+		return 0, err
+	}
+	lineHash, count, err := parseLine(line)
+	if err != nil || lineHash != hash {
+		return 0, err
+	}
+	return count, nil
+}
+
+// lineFrom returns the first line starting at or after the offset and
+// its start. The line is nil if no line starts at or after the offset
+func (d *Dump) lineFrom(off int64) (int64, []byte, error) {
+	start := off
+	if off > 0 {
+		// a line starts at off only if the byte before is a newline
+		nl, err := d.indexNewline(off - 1)
+		if err != nil || nl < 0 {
+			return 0, nil, err
+		}
+		start = nl + 1
+	}
+	if start >= d.size {
+		return start, nil, nil
+	}
+	end, err := d.indexNewline(start)
+	if err != nil {
+		return 0, nil, err
+	}
+	if end < 0 {
+		end = d.size
+	}
+	line := make([]byte, end-start)
+	if _, err := d.file.ReadAt(line, start); err != nil {
+		return 0, nil, err
+	}
+	return start, bytes.TrimRight(line, "\r"), nil
+}
+
+// indexNewline returns the offset of the first newline
+// at or after off or -1 if there is none
+func (d *Dump) indexNewline(off int64) (int64, error) {
+	buf := make([]byte, chunkSize)
+	for off < d.size {
+		n, err := d.file.ReadAt(buf, off)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return off + int64(i), nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		off += int64(n)
+	}
+	return -1, nil
+}
+
+// parseLine splits a line of the form HASH:COUNT
+func parseLine(line []byte) (string, int, error) {
+	i := bytes.IndexByte(line, ':')
+	if i != hashLen {
+		return "", 0, ErrInvalidDump
+	}
+	hash := strings.ToUpper(string(line[:i]))
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", 0, ErrInvalidDump
+	}
+	count, err := strconv.Atoi(string(bytes.TrimSpace(line[i+1:])))
+	if err != nil {
+		return "", 0, ErrInvalidDump
+	}
+	return hash, count, nil
+}
@@ -0,0 +1,87 @@
+package hibp
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// writeDump writes a dump of the passwords (with their index + 1
+// as count) ordered by hash and separated by sep
+func writeDump(t *testing.T, passwords []string, sep string) string {
+	var lines []string
+	for i, p := range passwords {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(p), i+1))
+	}
+	sort.Strings(lines)
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, sep)+sep), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCount(t *testing.T) {
+	var passwords []string
+	for i := 0; i < 500; i++ {
+		passwords = append(passwords, fmt.Sprintf("password-%d", i))
+	}
+	for _, sep := range []string{"\n", "\r\n"} {
+		d, err := Open(writeDump(t, passwords, sep))
+		if err != nil {
+			t.Fatalf("hibp.Open: want: nil, have: %v", err)
+		}
+		for i, p := range passwords {
+			count, err := d.Count(p)
+			if err != nil {
+				t.Fatalf("hibp.Count: want: nil, have: %v", err)
+			}
+			if count != i+1 {
+				t.Fatalf("hibp.Count: %q: want: %d, have: %d", p, i+1, count)
+			}
+		}
+		for _, p := range []string{"", "not-breached", "password-500"} {
+			count, err := d.Count(p)
+			if err != nil || count != 0 {
+				t.Fatalf("hibp.Count: %q: want: 0, have: %d (%v)", p, count, err)
+			}
+		}
+		d.Close()
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	dir := t.TempDir()
+	tt := []struct {
+		name    string
+		content string
+	}{
+		{name: "empty", content: ""},
+		{name: "plain text", content: "password\n123456\n"},
+		{name: "ntlm-like", content: "8846F7EAEE8FB117AD06BDD830B7586C:42\n"},
+		{name: "no count", content: sha1Hex("password") + ":\n"},
+	}
+	for _, tc := range tt {
+		path := filepath.Join(dir, tc.name)
+		if err := ioutil.WriteFile(path, []byte(tc.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(path); err != ErrInvalidDump {
+			t.Fatalf("hibp.Open: %s: want: %v, have: %v", tc.name, ErrInvalidDump, err)
+		}
+	}
+	if _, err := Open(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("hibp.Open: want: not exist, have: %v", err)
+	}
+}
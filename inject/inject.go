@@ -0,0 +1,176 @@
+// Package inject renders templates referencing sherlock secrets either through
+// {{ sherlock "group@account/field" }} or through sherlock://group/account/field
+// references. Only these references are replaced, any other text (including
+// the actions of Go, Helm or Jinja templates) is written as it is
+package inject
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// uriScheme starts a sherlock://group/account[/field] reference
+	uriScheme = "sherlock://"
+	// uriSegment is a group, account or field of a sherlock:// reference
+	uriSegment = `(?:[A-Za-z0-9._~-]|%[0-9A-Fa-f]{2})+`
+	// maxSnippet is the length of the template text shown
+	// in the error of an invalid reference
+	maxSnippet = 40
+)
+
+var (
+	ErrInvalidReference = fmt.Errorf("invalid sherlock reference")
+
+	// candidateRegex matches everything a reference can start with
+	candidateRegex = regexp.MustCompile(`\{\{|sherlock://`)
+	// actionStartRegex matches the start of an action calling sherlock.
+	// Other actions are not references and left untouched
+	actionStartRegex = regexp.MustCompile(`^\{\{\s*sherlock\b`)
+	// actionRegex matches a {{ sherlock "query" }} reference
+	actionRegex = regexp.MustCompile(`^\{\{\s*sherlock\s*("(?:[^"\\\n]|\\.)*")\s*\}\}`)
+	// uriRegex matches a sherlock://group/account[/field] reference.
+	// Characters other than letters, digits and -._~ are percent-encoded
+	uriRegex = regexp.MustCompile(`^sherlock://(` + uriSegment + `)/(` + uriSegment + `)(?:/(` + uriSegment + `))?`)
+)
+
+// reference is a reference to a sherlock secret found in a template
+type reference struct {
+	// start and end are the offsets of the reference in the template
+	start, end int
+	// query is group@account or group@account/field
+	query string
+}
+
+// References returns the queries (group@account or group@account/field)
+// referenced by the template in the order of their first appearance
+func References(tmpl string) ([]string, error) {
+	found, err := parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	seen := make(map[string]bool)
+	for _, ref := range found {
+		if !seen[ref.query] {
+			seen[ref.query] = true
+			refs = append(refs, ref.query)
+		}
+	}
+	return refs, nil
+}
+
+// Render writes the template with all references replaced by the values
+// returned by lookup. Rendering stops at the first failing lookup
+func Render(w io.Writer, tmpl string, lookup func(query string) (string, error)) error {
+	refs, err := parse(tmpl)
+	if err != nil {
+		return err
+	}
+	var pos int
+	for _, ref := range refs {
+		value, err := lookup(ref.query)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, tmpl[pos:ref.start]+value); err != nil {
+			return err
+		}
+		pos = ref.end
+	}
+	_, err = io.WriteString(w, tmpl[pos:])
+	return err
+}
+
+// parse finds all references of the template. Text looking like a
+// reference which is not a valid one fails the parsing
+func parse(tmpl string) ([]reference, error) {
+	var refs []reference
+	var pos int
+	for _, loc := range candidateRegex.FindAllStringIndex(tmpl, -1) {
+		if loc[0] < pos {
+			// part of the previous reference
+			continue
+		}
+		rest := tmpl[loc[0]:]
+		var (
+			length int
+			query  string
+			err    error
+		)
+		switch {
+		case strings.HasPrefix(rest, uriScheme):
+			length, query, err = parseURI(rest)
+		case actionStartRegex.MatchString(rest):
+			length, query, err = parseAction(rest)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, invalidReference(tmpl, loc[0])
+		}
+		refs = append(refs, reference{start: loc[0], end: loc[0] + length, query: query})
+		pos = loc[0] + length
+	}
+	return refs, nil
+}
+
+// parseAction parses a {{ sherlock "query" }} reference at the start of s
+// and returns its length and query
+func parseAction(s string) (int, string, error) {
+	m := actionRegex.FindStringSubmatch(s)
+	if m == nil {
+		return 0, "", ErrInvalidReference
+	}
+	query, err := strconv.Unquote(m[1])
+	if err != nil {
+		return 0, "", err
+	}
+	return len(m[0]), query, nil
+}
+
+// parseURI parses a sherlock://group/account[/field] reference at the
+// start of s and returns its length and query
+func parseURI(s string) (int, string, error) {
+	m := uriRegex.FindStringSubmatch(s)
+	if m == nil {
+		return 0, "", ErrInvalidReference
+	}
+	var parts []string
+	for _, segment := range m[1:] {
+		if segment == "" {
+			continue
+		}
+		part, err := url.PathUnescape(segment)
+		if err != nil {
+			return 0, "", err
+		}
+		// encoded separators would change the meaning of the query
+		if strings.ContainsAny(part, "@/") {
+			return 0, "", ErrInvalidReference
+		}
+		parts = append(parts, part)
+	}
+	query := parts[0] + "@" + parts[1]
+	if len(parts) == 3 {
+		query += "/" + parts[2]
+	}
+	return len(m[0]), query, nil
+}
+
+// invalidReference reports the line and the text of an invalid reference
+func invalidReference(tmpl string, offset int) error {
+	line := strings.Count(tmpl[:offset], "\n") + 1
+	snippet := tmpl[offset:]
+	if i := strings.IndexByte(snippet, '\n'); i >= 0 {
+		snippet = snippet[:i]
+	}
+	if len(snippet) > maxSnippet {
+		snippet = snippet[:maxSnippet] + "..."
+	}
+	return fmt.Errorf("%w in line %d: %s", ErrInvalidReference, line, snippet)
+}
@@ -2,116 +2,300 @@ package internal
 
 import (
 	"fmt"
-	"math"
 	"strings"
 	"time"
 
 	"github.com/KonstantinGasser/required"
-	"github.com/KonstantinGasser/sherlock/security"
 )
 
 var (
 	ErrInsecurePassword         = fmt.Errorf("provided password is insecure (use --insecure to ignore this message)")
 	ErrInvalidAccountName       = fmt.Errorf("account name must be a consecutive string")
 	ErrMissingValues            = fmt.Errorf("account is missing required values")
-	ErrInvalidAccountNameSymbol = fmt.Errorf("account name invalid. Please avoid using '@' character")
-
-	// expirationDur refers to the month until a password is
-	// not marked as expired
-	expirationDur = 6
+	ErrInvalidAccountNameSymbol = fmt.Errorf("account name invalid. Please avoid using '@' and '/' characters")
+	ErrInvalidField             = fmt.Errorf("field must be in the form key=value")
+	ErrInvalidFieldKey          = fmt.Errorf("field key must be a consecutive string without '@', '/' or '='")
+	ErrReservedField            = fmt.Errorf("field key is reserved for a built-in account field")
+	ErrNoSuchField              = fmt.Errorf("field not found")
+	ErrPasswordUnchanged        = fmt.Errorf("new password equals the current password")
 )
 
 // fieldUpdate is a function which can alter the fields of
 // an account
 type fieldUpdate func(*account) error
 
+const (
+	// standard fields of an account which can be set
+	// as any custom field
+	fieldUsername = "username"
+	fieldURL      = "url"
+	fieldNotes    = "notes"
+
+	// fieldPreviousPassword refers to the password before the last rotation
+	fieldPreviousPassword = "previous_password"
+
+	// secretMask replaces the value of secret fields
+	secretMask = "******"
+)
+
+// reservedFields cannot be used as custom field keys
+// since they refer to the built-in account fields
+var reservedFields = []string{"name", "password", "tag", "created_on", "updated_on", "rotated_on", "rotation", fieldPreviousPassword, "fields"}
+
 type account struct {
 	Name      string    `json:"name" required:"yes"`
 	Password  string    `json:"password" required:"yes"`
 	Tag       string    `json:"tag"`
+	Username  string    `json:"username,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	Notes     string    `json:"notes,omitempty"`
+	Fields    []*field  `json:"fields,omitempty"`
 	CreatedOn time.Time `json:"created_on" required:"yes"`
 	UpdatedOn time.Time `json:"updated_on"`
+	// RotatedOn is the last time the password was changed. It equals
+	// CreatedOn as long as the password was never changed. Accounts
+	// created before it was tracked are seeded once (see seedRotatedOn)
+	RotatedOn time.Time `json:"rotated_on"`
+	// PreviousPassword is the password before the last rotation. It is
+	// kept in case the password change on the remote site failed
+	PreviousPassword string `json:"previous_password,omitempty"`
+	// Rotation overwrites the rotation policy of the tag and group
+	Rotation *Period `json:"rotation,omitempty"`
+}
+
+// field is a custom key/value pair of an account. The value
+// of secret fields is masked unless explicitly requested
+type field struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Secret bool   `json:"secret"`
 }
 
-// NewAccount creates a new Account and if insecure=false checks the password strength
-// returning an err if strength security.Low
-func NewAccount(query, password, tag string, insecure bool) (*account, error) {
+// NewAccount creates a new Account. The password strength is checked against
+// the minimum entropy of the group once the account is added (see OptAddAccount)
+func NewAccount(query, password, tag string) (*account, error) {
 	_, acc, err := SplitQuery(query)
 	if err != nil {
 		return nil, err
 	}
+	now := time.Now()
 	a := account{
 		Name:      acc,
 		Password:  password,
-		CreatedOn: time.Now(),
-		UpdatedOn: time.Now(),
+		CreatedOn: now,
+		UpdatedOn: now,
+		RotatedOn: now,
 		Tag:       tag,
 	}
 	if err := a.valid(); err != nil {
 		return nil, err
 	}
+	return &a, nil
+}
+
+// Expiration computes the rotation status of the password under the rotation
+// period. The period starts with the last password change or, for passwords
+// never changed, the creation of the account
+func (a account) Expiration(period Period) Expiration {
+	changed := a.RotatedOn
+	if changed.IsZero() {
+		changed = a.CreatedOn
+	}
+	return expirationAt(changed, period, time.Now())
+}
 
-	if insecure {
-		return &a, nil
+// seedRotatedOn sets RotatedOn of accounts created before the last password
+// change was tracked. Back then every change of the password updated
+// UpdatedOn which is the best guess of the last password change left
+func (a *account) seedRotatedOn() {
+	if !a.RotatedOn.IsZero() {
+		return
 	}
-	if err := a.secure(); err != nil {
-		return nil, err
+	a.RotatedOn = a.UpdatedOn
+	if a.RotatedOn.Before(a.CreatedOn) {
+		a.RotatedOn = a.CreatedOn
 	}
-	return &a, nil
 }
 
-// Expiration computes whether an account password is marked as expired
-// or not. By default a password counts as expired if the updated field
-// is >= expirationDur month ago
-func (a account) Expiration() string {
-	// to the last time the password was updated add the maxExpirationDuration
-	expirationDate := a.UpdatedOn.AddDate(0, expirationDur, 0)
+// SetField sets a standard field (username, url, notes) or a custom field
+// of the account. Custom fields which already exist are overwritten
+func (a *account) SetField(key, value string, secret bool) error {
+	key = strings.TrimSpace(key)
+	if err := validFieldKey(key); err != nil {
+		return err
+	}
+	switch key {
+	case fieldUsername:
+		a.Username = value
+		return nil
+	case fieldURL:
+		a.URL = value
+		return nil
+	case fieldNotes:
+		a.Notes = value
+		return nil
+	}
+	for _, f := range a.Fields {
+		if f.Key == key {
+			f.Value = value
+			f.Secret = secret
+			return nil
+		}
+	}
+	a.Fields = append(a.Fields, &field{Key: key, Value: value, Secret: secret})
+	return nil
+}
 
-	diff := time.Since(expirationDate)
+// deleteField removes a custom field or clears a standard field
+func (a *account) deleteField(key string) error {
+	switch key {
+	case fieldUsername, fieldURL, fieldNotes:
+		return a.SetField(key, "", false)
+	}
+	for i, f := range a.Fields {
+		if f.Key == key {
+			a.Fields = append(a.Fields[:i], a.Fields[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoSuchField
+}
 
-	// dont panic: if hours is zero division by zero will
-	// cause days to be zero (as by the go language)
-	days := int(math.Ceil(diff.Hours() / 24))
-	hours := int(math.Ceil(diff.Hours())) % 24
+// Field returns the value of a field of the account. Besides the custom
+// fields, the built-in fields name, password and tag and the standard
+// fields can be requested. An empty name refers to the password
+func (a account) Field(name string) (string, error) {
+	switch name {
+	case "", "password":
+		return a.Password, nil
+	case fieldPreviousPassword:
+		if a.PreviousPassword == "" {
+			return "", fmt.Errorf("%w: the password was never rotated", ErrNoSuchField)
+		}
+		return a.PreviousPassword, nil
+	case "name":
+		return a.Name, nil
+	case "tag":
+		return a.Tag, nil
+	case fieldUsername:
+		return a.Username, nil
+	case fieldURL:
+		return a.URL, nil
+	case fieldNotes:
+		return a.Notes, nil
+	}
+	for _, f := range a.Fields {
+		if f.Key == name {
+			return f.Value, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q (available fields: %s)", ErrNoSuchField, name, strings.Join(a.fieldNames(), ", "))
+}
 
-	if hours > 0 { // password has expired
-		return fmt.Sprintf("expired %v days %v hours ago", days, hours)
+// fieldNames lists all fields which can be requested with Field
+func (a account) fieldNames() []string {
+	names := []string{"password", "name", "tag", fieldUsername, fieldURL, fieldNotes}
+	if a.PreviousPassword != "" {
+		names = append(names, fieldPreviousPassword)
 	}
+	for _, f := range a.Fields {
+		names = append(names, f.Key)
+	}
+	return names
+}
+
+// fieldSummary lists all set standard and custom fields as key=value
+// pairs. Values of secret fields are masked if !reveal
+func (a account) fieldSummary(reveal bool) string {
+	var pairs []string
+	for _, std := range []struct{ key, value string }{
+		{fieldUsername, a.Username},
+		{fieldURL, a.URL},
+		{fieldNotes, a.Notes},
+	} {
+		if std.value != "" {
+			pairs = append(pairs, std.key+"="+std.value)
+		}
+	}
+	for _, f := range a.Fields {
+		value := f.Value
+		if f.Secret && !reveal {
+			value = secretMask
+		}
+		pairs = append(pairs, f.Key+"="+value)
+	}
+	return strings.Join(pairs, "\n")
+}
+
+// ParseField splits a key=value pair as passed to the --field flags. The
+// value may be empty ("key=" or "key")
+func ParseField(pair string) (string, string, error) {
+	set := strings.SplitN(pair, "=", 2)
+	key := strings.TrimSpace(set[0])
+	if key == "" {
+		return "", "", ErrInvalidField
+	}
+	if len(set) == 1 {
+		return key, "", nil
+	}
+	return key, set[1], nil
+}
 
-	// since days and hours will if valid be negative
-	// multiply by -1 to make them positive
-	validDays := days * (-1)
-	validHours := hours * (-1)
-	return fmt.Sprintf("valid for %v days %v hours", validDays, validHours)
+func validFieldKey(key string) error {
+	if key == "" || strings.ContainsAny(key, " @/=") {
+		return ErrInvalidFieldKey
+	}
+	for _, reserved := range reservedFields {
+		if key == reserved {
+			return ErrReservedField
+		}
+	}
+	return nil
 }
 
 func (a account) valid() error {
 	if err := required.Atomic(&a); err != nil {
 		return ErrMissingValues
 	}
-	if set := strings.Split(a.Name, " "); len(set) > 1 {
+	return validName(a.Name)
+}
+
+// validName reports whether the name can be used in a query
+// (group@account/field) to refer to the account
+func validName(name string) error {
+	if set := strings.Split(name, " "); len(set) > 1 {
 		return ErrInvalidAccountName
 	}
+	if strings.Contains(name, fieldSplitPoint) || strings.Contains(name, querySplitPoint) {
+		return ErrInvalidAccountNameSymbol
+	}
 	return nil
 }
 
 func updateFieldName(name string) fieldUpdate {
 	return func(a *account) error {
-		a.Name = strings.TrimSpace(name)
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return ErrMissingValues
+		}
+		if err := validName(name); err != nil {
+			return err
+		}
+		a.Name = name
 		return nil
 	}
 }
 
-func updateFieldPassword(password string, insecure bool) fieldUpdate {
+func updateFieldPassword(password string) fieldUpdate {
 	return func(a *account) error {
-		a.Password = strings.TrimSpace(password)
-		if insecure {
-			a.UpdatedOn = time.Now()
-			return nil
-		}
-		if err := a.secure(); err != nil {
-			return err
+		password = strings.TrimSpace(password)
+		// the same password again is no rotation
+		if password == a.Password {
+			return ErrPasswordUnchanged
 		}
+		a.PreviousPassword = a.Password
+		a.Password = password
+		a.RotatedOn = time.Now()
 		return nil
 	}
 }
@@ -123,6 +307,18 @@ func updateFieldTag(tag string) fieldUpdate {
 	}
 }
 
+func updateFieldCustom(key, value string, secret bool) fieldUpdate {
+	return func(a *account) error {
+		return a.SetField(key, value, secret)
+	}
+}
+
+func deleteFieldCustom(key string) fieldUpdate {
+	return func(a *account) error {
+		return a.deleteField(key)
+	}
+}
+
 func (a *account) update(opt fieldUpdate) error {
 	if err := opt(a); err != nil {
 		return err
@@ -130,8 +326,3 @@ func (a *account) update(opt fieldUpdate) error {
 	a.UpdatedOn = time.Now()
 	return nil
 }
-
-// secure checks the Accounts on how secure it is
-func (a account) secure() error {
-	return security.PasswordStrength(a.Password)
-}
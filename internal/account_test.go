@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -11,89 +12,232 @@ func TestNewAccount(t *testing.T) {
 		name     string
 		password string
 		tag      string
-		insecure bool
 		created  bool
 	}{
 		{
 			name:     "group@testaccount",
 			password: "fsdf$35dfg0-43563sdf34",
 			tag:      "testing",
-			insecure: false,
 			created:  true,
 		},
-		{
-			name:     "group@testaccount",
-			password: "helloworld",
-			tag:      "testing",
-			insecure: false,
-			created:  false,
-		},
 		{
 			name:     "group@test account",
 			password: "helloworld",
 			tag:      "testing",
-			insecure: false,
 			created:  false,
 		},
 		{
 			name:     "group@testaccount",
 			password: "helloworld",
 			tag:      "testing",
-			insecure: true,
 			created:  true,
 		},
 		{
 			name:     "gr@up@testaccount",
 			password: "helloworld",
 			tag:      "testing",
-			insecure: true,
 			created:  false,
 		},
 		{
 			name:     "",
 			password: "",
 			tag:      "testing",
-			insecure: false,
 			created:  false,
 		},
 	}
 
 	for _, tc := range tt {
-		a, err := NewAccount(tc.name, tc.password, tc.tag, tc.insecure)
+		a, err := NewAccount(tc.name, tc.password, tc.tag)
 		if (tc.created && a == nil) || (!tc.created && a != nil) {
 			t.Fatalf("internal.NewAccount: want:created==%v, have: error==%v", tc.created, err)
 		}
 	}
 }
 
-func TestAccountExpiratin(t *testing.T) {
-
+func TestAccountExpiration(t *testing.T) {
 	tt := []struct {
-		name     string
-		a        account
-		expected string
+		name   string
+		a      account
+		period Period
+		state  string
 	}{
 		{
-			name: "expiration time 6 month from time.Now",
+			name:   "created now",
+			a:      account{CreatedOn: time.Now(), UpdatedOn: time.Now()},
+			period: DefaultRotation,
+			state:  ExpirationValid,
+		},
+		{
+			name:   "created a year ago",
+			a:      account{CreatedOn: time.Now().AddDate(-1, 0, 0), UpdatedOn: time.Now().AddDate(-1, 0, 0)},
+			period: DefaultRotation,
+			state:  ExpirationExpired,
+		},
+		{
+			name:   "updated but never rotated",
+			a:      account{CreatedOn: time.Now().AddDate(-1, 0, 0), UpdatedOn: time.Now()},
+			period: DefaultRotation,
+			state:  ExpirationExpired,
+		},
+		{
+			name: "rotated after the creation",
 			a: account{
+				CreatedOn: time.Now().AddDate(-1, 0, 0),
 				UpdatedOn: time.Now(),
+				RotatedOn: time.Now().AddDate(0, 0, -20),
 			},
-			expected: "valid for 180 days 22 hours",
+			period: Period{Days: 30},
+			state:  ExpirationExpiring,
 		},
 		{
-			name: "expired 6 month ago",
-			a: account{
-				UpdatedOn: time.Now().AddDate(-1, 0, 0),
-			},
-			expected: "expired 185 days 2 hours ago",
+			name:   "never expires",
+			a:      account{CreatedOn: time.Now().AddDate(-10, 0, 0)},
+			period: Period{Never: true},
+			state:  ExpirationNever,
 		},
 	}
 
 	for _, tc := range tt {
-		expText := tc.a.Expiration()
+		exp := tc.a.Expiration(tc.period)
+		if exp.State != tc.state {
+			t.Fatalf("account.Expiration: %s: want: %q, have: %q", tc.name, tc.state, exp.State)
+		}
+	}
+}
+
+func TestAccountSeedRotatedOn(t *testing.T) {
+	created := time.Date(2021, time.January, 31, 12, 0, 0, 0, time.UTC)
+	tt := []struct {
+		name string
+		a    account
+		want time.Time
+	}{
+		{
+			name: "changed before it was tracked",
+			a:    account{CreatedOn: created, UpdatedOn: created.AddDate(0, 2, 0)},
+			want: created.AddDate(0, 2, 0),
+		},
+		{
+			name: "never updated",
+			a:    account{CreatedOn: created},
+			want: created,
+		},
+		{
+			name: "already tracked",
+			a:    account{CreatedOn: created, UpdatedOn: created.AddDate(1, 0, 0), RotatedOn: created.AddDate(0, 1, 0)},
+			want: created.AddDate(0, 1, 0),
+		},
+	}
 
-		if expText != tc.expected {
-			t.Fatalf("account.Expiration: want=%q, have: %q", tc.expected, expText)
+	for _, tc := range tt {
+		tc.a.seedRotatedOn()
+		if !tc.a.RotatedOn.Equal(tc.want) {
+			t.Fatalf("account.seedRotatedOn: %s: want: %v, have: %v", tc.name, tc.want, tc.a.RotatedOn)
 		}
 	}
 }
+
+func TestAccountSetField(t *testing.T) {
+	tt := []struct {
+		name   string
+		key    string
+		value  string
+		secret bool
+		err    error
+	}{
+		{
+			name:  "standard field",
+			key:   "username",
+			value: "sherlock",
+			err:   nil,
+		},
+		{
+			name:   "custom secret field",
+			key:    "api_key",
+			value:  "221b",
+			secret: true,
+			err:    nil,
+		},
+		{
+			name:  "reserved field",
+			key:   "password",
+			value: "221b",
+			err:   ErrReservedField,
+		},
+		{
+			name:  "invalid field key",
+			key:   "db/host",
+			value: "localhost",
+			err:   ErrInvalidFieldKey,
+		},
+	}
+
+	for _, tc := range tt {
+		var a account
+		err := a.SetField(tc.key, tc.value, tc.secret)
+		if err != tc.err {
+			t.Fatalf("account.SetField: %s: want: %v, have: %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestAccountFieldSummary(t *testing.T) {
+	a := account{Username: "sherlock"}
+	if err := a.SetField("host", "bakerstreet", false); err != nil {
+		t.Fatalf("account.SetField: want: nil, have: %v", err)
+	}
+	if err := a.SetField("api_key", "221b", true); err != nil {
+		t.Fatalf("account.SetField: want: nil, have: %v", err)
+	}
+
+	masked := "username=sherlock\nhost=bakerstreet\napi_key=" + secretMask
+	if summary := a.fieldSummary(false); summary != masked {
+		t.Fatalf("account.fieldSummary: want: %q, have: %q", masked, summary)
+	}
+	revealed := "username=sherlock\nhost=bakerstreet\napi_key=221b"
+	if summary := a.fieldSummary(true); summary != revealed {
+		t.Fatalf("account.fieldSummary: want: %q, have: %q", revealed, summary)
+	}
+
+	if err := a.deleteField("host"); err != nil {
+		t.Fatalf("account.deleteField: want: nil, have: %v", err)
+	}
+	if err := a.deleteField("host"); err != ErrNoSuchField {
+		t.Fatalf("account.deleteField: want: %v, have: %v", ErrNoSuchField, err)
+	}
+}
+
+func TestAccountField(t *testing.T) {
+	a := account{Name: "db", Password: "221b", Username: "sherlock"}
+	if err := a.SetField("port", "5432", false); err != nil {
+		t.Fatalf("account.SetField: want: nil, have: %v", err)
+	}
+
+	tt := []struct {
+		field string
+		value string
+		err   error
+	}{
+		{field: "", value: "221b", err: nil},
+		{field: "username", value: "sherlock", err: nil},
+		{field: "port", value: "5432", err: nil},
+		{field: "host", value: "", err: ErrNoSuchField},
+		{field: "previous_password", value: "", err: ErrNoSuchField},
+	}
+	for _, tc := range tt {
+		value, err := a.Field(tc.field)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("account.Field: %q: want: %v, have: %v", tc.field, tc.err, err)
+		}
+		if value != tc.value {
+			t.Fatalf("account.Field: %q: want: %q, have: %q", tc.field, tc.value, value)
+		}
+	}
+
+	if err := a.update(updateFieldPassword("baker-street")); err != nil {
+		t.Fatalf("account.update: want: nil, have: %v", err)
+	}
+	if previous, err := a.Field("previous_password"); err != nil || previous != "221b" {
+		t.Fatalf("account.Field: %q: want: %q, have: %q (%v)", "previous_password", "221b", previous, err)
+	}
+}
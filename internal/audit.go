@@ -0,0 +1,413 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/KonstantinGasser/sherlock/security"
+)
+
+const (
+	// ReuseIdentical marks accounts sharing the same password
+	ReuseIdentical = "identical"
+	// ReuseSimilar marks accounts with near-identical passwords
+	// like Summer2020! and summer2021
+	ReuseSimilar = "similar"
+
+	// minBaseLength and minSimilarLength are the lengths the base word
+	// of a password and a password need to be compared for similarity
+	// so short passwords do not match by chance
+	minBaseLength    = 6
+	minSimilarLength = 8
+	// maxSimilarDistance is the number of edits up to which
+	// two passwords are near-identical
+	maxSimilarDistance = 2
+)
+
+// checks of the audit report
+const (
+	CheckWeak         = "weak"
+	CheckExpired      = "expired"
+	CheckNeverRotated = "never-rotated"
+	CheckUntagged     = "untagged"
+	CheckReused       = "reused"
+	CheckBreached     = "breached"
+)
+
+// Checks lists all checks of the audit report in the order of their findings
+var Checks = []string{CheckBreached, CheckWeak, CheckReused, CheckExpired, CheckNeverRotated, CheckUntagged}
+
+// checkPenalty is the number of points the score of an
+// account drops for a finding of the check
+var checkPenalty = map[string]int{
+	CheckBreached:     40,
+	CheckWeak:         25,
+	CheckReused:       25,
+	CheckExpired:      15,
+	CheckNeverRotated: 5,
+	CheckUntagged:     2,
+}
+
+var (
+	ErrUnknownCheck = fmt.Errorf("unknown audit check (use %s)", strings.Join(Checks, ", "))
+)
+
+// leetReplacer undoes common character substitutions
+var leetReplacer = strings.NewReplacer(
+	"0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s",
+)
+
+// Breach is an account whose password appears in known breaches.
+// It never holds the password itself
+type Breach struct {
+	Group   string `json:"group" yaml:"group"`
+	Account string `json:"account" yaml:"account"`
+	Count   int    `json:"count" yaml:"count"`
+}
+
+// Reuse is a cluster of accounts (group@account) sharing the same
+// or a near-identical password. It never holds the password itself
+type Reuse struct {
+	Kind     string   `json:"kind" yaml:"kind"`
+	Accounts []string `json:"accounts" yaml:"accounts"`
+}
+
+// Breached looks up the password of every account of the group through
+// count and returns the accounts with a password found in a breach
+func (g group) Breached(count func(password string) (int, error)) ([]Breach, error) {
+	var breaches []Breach
+	for _, a := range g.Accounts {
+		n, err := count(a.Password)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			breaches = append(breaches, Breach{Group: g.GID, Account: a.Name, Count: n})
+		}
+	}
+	return breaches, nil
+}
+
+// SkippedGroup is a group which could not be unlocked for an audit
+type SkippedGroup struct {
+	Group  string `json:"group" yaml:"group"`
+	Reason string `json:"reason" yaml:"reason"`
+}
+
+// Audit runs checks across the accounts of several unlocked groups
+type Audit struct {
+	groups  []*group
+	skipped []SkippedGroup
+}
+
+// NewAudit returns an Audit without any groups
+func NewAudit() *Audit {
+	return &Audit{}
+}
+
+// Add adds a group loaded with LoadGroup to the audit
+func (a *Audit) Add(g *group) {
+	a.groups = append(a.groups, g)
+}
+
+// Skip records a group which could not be unlocked. Its
+// accounts are missing from the report
+func (a *Audit) Skip(gid string, reason error) {
+	a.skipped = append(a.skipped, SkippedGroup{Group: gid, Reason: reason.Error()})
+}
+
+// Breached runs group.Breached for all groups of the audit
+func (a *Audit) Breached(count func(password string) (int, error)) ([]Breach, error) {
+	breaches := make([]Breach, 0)
+	for _, g := range a.groups {
+		found, err := g.Breached(count)
+		if err != nil {
+			return nil, err
+		}
+		breaches = append(breaches, found...)
+	}
+	return breaches, nil
+}
+
+// Reused returns the clusters of accounts across all groups of the audit
+// sharing the same or a near-identical password.
+//
+// Passwords are compared through keyed fingerprints (HMAC-SHA256 with a key
+// only living for the call) so equal passwords are found without keeping
+// them as map keys. Distinct passwords are similar if they only differ by
+// case, common character substitutions, digits or symbols or by at most
+// maxSimilarDistance edits
+func (a *Audit) Reused() ([]Reuse, error) {
+	key := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	fingerprint := func(s string) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(s))
+		return string(mac.Sum(nil))
+	}
+
+	// distinct passwords with the accounts using them
+	type password struct {
+		value    string
+		base     string
+		accounts []string
+	}
+	var passwords []*password
+	byFingerprint := make(map[string]*password)
+	for _, g := range a.groups {
+		for _, acc := range g.Accounts {
+			fp := fingerprint(acc.Password)
+			p, ok := byFingerprint[fp]
+			if !ok {
+				p = &password{value: acc.Password}
+				if base := baseWord(acc.Password); len(base) >= minBaseLength {
+					p.base = fingerprint(base)
+				}
+				byFingerprint[fp] = p
+				passwords = append(passwords, p)
+			}
+			p.accounts = append(p.accounts, g.GID+querySplitPoint+acc.Name)
+		}
+	}
+
+	// union-find over the distinct passwords joining similar ones
+	parent := make([]int, len(passwords))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range passwords {
+		for j := i + 1; j < len(passwords); j++ {
+			if similar(passwords[i].value, passwords[j].value, passwords[i].base, passwords[j].base) {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	clusters := make(map[int][]*password)
+	var roots []int
+	for i, p := range passwords {
+		root := find(i)
+		if _, ok := clusters[root]; !ok {
+			roots = append(roots, root)
+		}
+		clusters[root] = append(clusters[root], p)
+	}
+	reused := make([]Reuse, 0)
+	for _, root := range roots {
+		cluster := clusters[root]
+		var accounts []string
+		for _, p := range cluster {
+			accounts = append(accounts, p.accounts...)
+		}
+		if len(accounts) < 2 {
+			continue
+		}
+		kind := ReuseIdentical
+		if len(cluster) > 1 {
+			kind = ReuseSimilar
+		}
+		sort.Strings(accounts)
+		reused = append(reused, Reuse{Kind: kind, Accounts: accounts})
+	}
+	return reused, nil
+}
+
+// baseWord reduces a password to its lower case letters. Leading and trailing
+// digits or symbols (like 2020!) are dropped before common character
+// substitutions in the rest are undone
+func baseWord(password string) string {
+	isLetter := func(r rune) bool { return r >= 'a' && r <= 'z' }
+	word := strings.TrimFunc(strings.ToLower(password), func(r rune) bool {
+		// @ and $ are kept since they often replace a leading a or s
+		return !isLetter(r) && r != '@' && r != '$'
+	})
+	return strings.Map(func(r rune) rune {
+		if isLetter(r) {
+			return r
+		}
+		return -1
+	}, leetReplacer.Replace(word))
+}
+
+// similar reports whether two distinct passwords are near-identical
+// either by their base word fingerprints or by their edit distance
+func similar(a, b, baseA, baseB string) bool {
+	if baseA != "" && baseA == baseB {
+		return true
+	}
+	if len(a) < minSimilarLength || len(b) < minSimilarLength {
+		return false
+	}
+	return distance(a, b) <= maxSimilarDistance
+}
+
+// distance returns the Levenshtein distance of a and b
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(first int, rest ...int) int {
+	for _, n := range rest {
+		if n < first {
+			first = n
+		}
+	}
+	return first
+}
+
+// Finding is an issue of one or more accounts (group@account)
+// found by an audit. It never holds a password
+type Finding struct {
+	Check    string   `json:"check" yaml:"check"`
+	Accounts []string `json:"accounts" yaml:"accounts"`
+	Detail   string   `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+// Report is the result of an audit
+type Report struct {
+	// Score is the average score (0-100) of all accounts. Every
+	// account starts with 100 points and loses points per finding.
+	// Without any audited account the score is 0
+	Score    int `json:"score" yaml:"score"`
+	Groups   int `json:"groups" yaml:"groups"`
+	Accounts int `json:"accounts" yaml:"accounts"`
+	// Skipped are the groups which could not be unlocked. A report
+	// with skipped groups is incomplete
+	Skipped []SkippedGroup `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+	// Summary is the number of accounts affected per check
+	Summary  map[string]int `json:"summary" yaml:"summary"`
+	Findings []Finding      `json:"findings" yaml:"findings"`
+}
+
+// ReportOptions select the checks of an audit report
+type ReportOptions struct {
+	// Checks run by the report. All checks are run if empty
+	Checks []string
+	// BreachCount looks up how often a password appears in breaches.
+	// The breached check is skipped without it
+	BreachCount func(password string) (int, error)
+}
+
+// Report runs the selected checks across all groups of the audit and
+// scores the result
+func (a *Audit) Report(opts ReportOptions) (Report, error) {
+	enabled := make(map[string]bool)
+	for _, check := range opts.Checks {
+		if _, ok := checkPenalty[check]; !ok {
+			return Report{}, fmt.Errorf("%w: %q", ErrUnknownCheck, check)
+		}
+		enabled[check] = true
+	}
+	run := func(check string) bool {
+		return len(opts.Checks) == 0 || enabled[check]
+	}
+
+	report := Report{
+		Groups:   len(a.groups),
+		Skipped:  a.skipped,
+		Summary:  make(map[string]int),
+		Findings: make([]Finding, 0),
+	}
+	findings := make(map[string][]Finding)
+	add := func(check, detail string, accounts ...string) {
+		findings[check] = append(findings[check], Finding{Check: check, Accounts: accounts, Detail: detail})
+	}
+
+	for _, g := range a.groups {
+		for _, acc := range g.Accounts {
+			report.Accounts++
+			query := g.GID + querySplitPoint + acc.Name
+			if run(CheckWeak) {
+				if err := g.secure(acc.Password); err != nil {
+					strength := security.MeasureStrength(acc.Password)
+					add(CheckWeak, fmt.Sprintf("~%.0f of %.0f bits", strength.Entropy, g.RequiredEntropy()), query)
+				}
+			}
+			if run(CheckExpired) {
+				if exp := g.Expiration(acc); exp.Expired() {
+					add(CheckExpired, exp.String()+" ("+exp.Policy+" policy)", query)
+				}
+			}
+			if run(CheckNeverRotated) && !acc.RotatedOn.After(acc.CreatedOn) {
+				add(CheckNeverRotated, "created "+acc.CreatedOn.Format("2006-01-02"), query)
+			}
+			if run(CheckUntagged) && acc.Tag == "" {
+				add(CheckUntagged, "", query)
+			}
+		}
+	}
+	if run(CheckReused) {
+		reused, err := a.Reused()
+		if err != nil {
+			return Report{}, err
+		}
+		for _, r := range reused {
+			add(CheckReused, r.Kind, r.Accounts...)
+		}
+	}
+	if run(CheckBreached) && opts.BreachCount != nil {
+		breaches, err := a.Breached(opts.BreachCount)
+		if err != nil {
+			return Report{}, err
+		}
+		for _, b := range breaches {
+			add(CheckBreached, fmt.Sprintf("%d breaches", b.Count), b.Group+querySplitPoint+b.Account)
+		}
+	}
+
+	// every account loses the penalty of a check once
+	penalties := make(map[string]int)
+	for _, check := range Checks {
+		affected := make(map[string]bool)
+		for _, f := range findings[check] {
+			for _, acc := range f.Accounts {
+				affected[acc] = true
+			}
+		}
+		for acc := range affected {
+			penalties[acc] += checkPenalty[check]
+		}
+		if len(affected) > 0 {
+			report.Summary[check] = len(affected)
+		}
+		report.Findings = append(report.Findings, findings[check]...)
+	}
+	if report.Accounts > 0 {
+		var total int
+		for _, penalty := range penalties {
+			total += minInt(penalty, 100)
+		}
+		report.Score = 100 - total/report.Accounts
+	}
+	return report, nil
+}
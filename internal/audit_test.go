@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGroupBreached(t *testing.T) {
+	g := group{
+		GID: "test",
+		Accounts: []*account{
+			{Name: "github", Password: "123456"},
+			{Name: "gitlab", Password: "fsdf$35dfg0-43563sdf34"},
+		},
+	}
+	dump := map[string]int{"123456": 37359195}
+	breaches, err := g.Breached(func(password string) (int, error) {
+		return dump[password], nil
+	})
+	if err != nil {
+		t.Fatalf("group.Breached: want: nil, have: %v", err)
+	}
+	want := []Breach{{Group: "test", Account: "github", Count: 37359195}}
+	if !reflect.DeepEqual(breaches, want) {
+		t.Fatalf("group.Breached: want: %v, have: %v", want, breaches)
+	}
+}
+
+func TestAuditReused(t *testing.T) {
+	work := &group{
+		GID: "work",
+		Accounts: []*account{
+			{Name: "github", Password: "fsdf$35dfg0-43563sdf34"},
+			{Name: "jira", Password: "Summer2020!"},
+			{Name: "vpn", Password: "kq8#Lm2!vX9z"},
+			{Name: "wiki", Password: "a1b2"},
+		},
+	}
+	private := &group{
+		GID: "private",
+		Accounts: []*account{
+			{Name: "github", Password: "fsdf$35dfg0-43563sdf34"},
+			{Name: "mail", Password: "summer2021"},
+			{Name: "bank", Password: "kq8#Lm2!vX9y"},
+			{Name: "forum", Password: "c3d4"},
+			{Name: "shop", Password: "zT5&pW1@rN7^"},
+		},
+	}
+	audit := NewAudit()
+	audit.Add(work)
+	audit.Add(private)
+
+	reused, err := audit.Reused()
+	if err != nil {
+		t.Fatalf("Audit.Reused: want: nil, have: %v", err)
+	}
+	want := []Reuse{
+		{Kind: ReuseIdentical, Accounts: []string{"private@github", "work@github"}},
+		{Kind: ReuseSimilar, Accounts: []string{"private@mail", "work@jira"}},
+		{Kind: ReuseSimilar, Accounts: []string{"private@bank", "work@vpn"}},
+	}
+	if !reflect.DeepEqual(reused, want) {
+		t.Fatalf("Audit.Reused: want: %v, have: %v", want, reused)
+	}
+}
+
+func TestBaseWord(t *testing.T) {
+	tt := []struct {
+		password string
+		base     string
+	}{
+		{password: "Summer2020!", base: "summer"},
+		{password: "P@ssw0rd1", base: "password"},
+		{password: "2021-$ecret-2022", base: "secret"},
+		{password: "1234!", base: ""},
+	}
+	for _, tc := range tt {
+		if base := baseWord(tc.password); base != tc.base {
+			t.Fatalf("internal.baseWord: %q: want: %q, have: %q", tc.password, tc.base, base)
+		}
+	}
+}
+
+func TestAuditReport(t *testing.T) {
+	g := &group{
+		GID: "work",
+		Accounts: []*account{
+			{
+				Name:      "github",
+				Password:  "fsdf$35dfg0-43563sdf34",
+				Tag:       "dev",
+				CreatedOn: time.Now().AddDate(-1, 0, 0),
+				UpdatedOn: time.Now(),
+				RotatedOn: time.Now(),
+			},
+			{
+				Name:      "jira",
+				Password:  "helloworld",
+				CreatedOn: time.Now().AddDate(-1, 0, 0),
+				UpdatedOn: time.Now().AddDate(0, -7, 0),
+			},
+		},
+	}
+	audit := NewAudit()
+	audit.Add(g)
+	breaches := map[string]int{"helloworld": 5}
+	count := func(password string) (int, error) { return breaches[password], nil }
+
+	tt := []struct {
+		name    string
+		opts    ReportOptions
+		score   int
+		summary map[string]int
+	}{
+		{
+			name:  "all checks",
+			opts:  ReportOptions{BreachCount: count},
+			score: 100 - (40+25+15+5+2)/2,
+			summary: map[string]int{
+				CheckBreached: 1, CheckWeak: 1, CheckExpired: 1, CheckNeverRotated: 1, CheckUntagged: 1,
+			},
+		},
+		{
+			name:    "without breach count",
+			opts:    ReportOptions{},
+			score:   100 - (25+15+5+2)/2,
+			summary: map[string]int{CheckWeak: 1, CheckExpired: 1, CheckNeverRotated: 1, CheckUntagged: 1},
+		},
+		{
+			name:    "selected checks",
+			opts:    ReportOptions{Checks: []string{CheckWeak, CheckUntagged}, BreachCount: count},
+			score:   100 - (25+2)/2,
+			summary: map[string]int{CheckWeak: 1, CheckUntagged: 1},
+		},
+	}
+	for _, tc := range tt {
+		report, err := audit.Report(tc.opts)
+		if err != nil {
+			t.Fatalf("Audit.Report: %s: want: nil, have: %v", tc.name, err)
+		}
+		if report.Score != tc.score || report.Accounts != 2 || report.Groups != 1 {
+			t.Fatalf("Audit.Report: %s: want: score %d of 2 accounts in 1 group, have: %+v", tc.name, tc.score, report)
+		}
+		if !reflect.DeepEqual(report.Summary, tc.summary) {
+			t.Fatalf("Audit.Report: %s: want: %v, have: %v", tc.name, tc.summary, report.Summary)
+		}
+		for _, f := range report.Findings {
+			if !reflect.DeepEqual(f.Accounts, []string{"work@jira"}) {
+				t.Fatalf("Audit.Report: %s: want: only work@jira, have: %+v", tc.name, f)
+			}
+		}
+	}
+
+	if _, err := audit.Report(ReportOptions{Checks: []string{"typo"}}); !errors.Is(err, ErrUnknownCheck) {
+		t.Fatalf("Audit.Report: want: %v, have: %v", ErrUnknownCheck, err)
+	}
+
+	// nothing audited must never look healthy
+	empty := NewAudit()
+	empty.Skip("work", ErrWrongKey)
+	report, err := empty.Report(ReportOptions{})
+	if err != nil {
+		t.Fatalf("Audit.Report: want: nil, have: %v", err)
+	}
+	if report.Score != 0 || len(report.Skipped) != 1 || report.Skipped[0].Group != "work" {
+		t.Fatalf("Audit.Report: want: score 0 with work skipped, have: %+v", report)
+	}
+}
+
+func TestAuditNeverRotated(t *testing.T) {
+	created := time.Now().AddDate(-1, 0, 0)
+	legacy := &account{Name: "legacy", Password: "changed-before-tracking", CreatedOn: created, UpdatedOn: created.AddDate(0, 3, 0)}
+	legacy.seedRotatedOn()
+	g := &group{
+		GID: "work",
+		Accounts: []*account{
+			{Name: "new", Password: "never-changed", CreatedOn: created, UpdatedOn: time.Now(), RotatedOn: created},
+			{Name: "rotated", Password: "changed", CreatedOn: created, UpdatedOn: time.Now(), RotatedOn: created.AddDate(0, 1, 0)},
+			legacy,
+		},
+	}
+	audit := NewAudit()
+	audit.Add(g)
+	report, err := audit.Report(ReportOptions{Checks: []string{CheckNeverRotated}})
+	if err != nil {
+		t.Fatalf("Audit.Report: want: nil, have: %v", err)
+	}
+	if len(report.Findings) != 1 || !reflect.DeepEqual(report.Findings[0].Accounts, []string{"work@new"}) {
+		t.Fatalf("Audit.Report: want: only work@new never rotated, have: %+v", report.Findings)
+	}
+}
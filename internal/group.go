@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/KonstantinGasser/required"
 	"github.com/KonstantinGasser/sherlock/security"
@@ -12,19 +13,32 @@ import (
 const (
 	defaultGroupName = "default"
 	prettyDateLayout = "Monday, 02. January 2006"
+
+	// maxMinEntropy caps the minimum entropy a group can require
+	maxMinEntropy = 256
 )
 
 var (
 	ErrAccountExists          = fmt.Errorf("account for group already exists")
 	ErrNoSuchAccount          = fmt.Errorf("account not found")
 	ErrInvalidGroupName       = fmt.Errorf("group name must be a consecutive string")
-	ErrInvalidGroupNameSymbol = fmt.Errorf("group name invalid. Please avoid using '@' character")
+	ErrInvalidGroupNameSymbol = fmt.Errorf("group name invalid. Please avoid using '@' and '/' characters")
+	ErrInvalidMinEntropy      = fmt.Errorf("minimum entropy must be between 0 and %d bits", maxMinEntropy)
 )
 
 // Group groups Accounts
 type group struct {
 	GID      string     `json:"name" required:"yes"`
 	Accounts []*account `json:"accounts"`
+	// MinEntropy is the entropy in bits passwords of the group
+	// need. If not set security.DefaultMinEntropy is used
+	MinEntropy float64 `json:"min_entropy,omitempty"`
+	// Rotation is the rotation policy of the accounts of the group.
+	// If not set DefaultRotation is used
+	Rotation *Period `json:"rotation,omitempty"`
+	// TagRotation holds the rotation policies per tag which
+	// overwrite the rotation policy of the group
+	TagRotation map[string]Period `json:"tag_rotation,omitempty"`
 }
 
 func newDefaultGroup() *group {
@@ -102,40 +116,109 @@ func (g group) valid() error {
 	if set := strings.Split(g.GID, " "); len(set) != 1 {
 		return ErrInvalidGroupName
 	}
-	if strings.Contains(g.GID, querySplitPoint) {
+	// a group name is also a directory name and the start of a query
+	if strings.Contains(g.GID, querySplitPoint) || strings.Contains(g.GID, fieldSplitPoint) {
 		return ErrInvalidGroupNameSymbol
 	}
 	return nil
 }
 
-// secure evaluates the password strength of the group password
-func (g group) secure(groupKey string) error {
-	return security.PasswordStrength(groupKey)
+// RequiredEntropy returns the entropy in bits passwords
+// of the group need to be secure
+func (g group) RequiredEntropy() float64 {
+	if g.MinEntropy == 0 {
+		return security.DefaultMinEntropy
+	}
+	return g.MinEntropy
+}
+
+// secure evaluates the password strength of the group password or
+// an account password against the minimum entropy of the group
+func (g group) secure(password string) error {
+	if err := security.PasswordStrength(password, g.RequiredEntropy()); err != nil {
+		return fmt.Errorf("%w: %v", ErrInsecurePassword, err)
+	}
+	return nil
 }
 
-// Table builds the Group in such a way that it can be consumed by the tablewriter.Table
-func (g group) Table(verbose bool, filter ...func(*account) bool) [][]string {
+// Table builds the Group in such a way that it can be consumed by the tablewriter.Table.
+// Values of secret fields are only shown in verbose mode if reveal is true
+func (g group) Table(verbose, reveal bool, filter ...func(*account) bool) [][]string {
 	var accounts [][]string
 
 skipp:
-	for i, item := range g.Accounts {
+	for _, item := range g.Accounts {
 		for _, f := range filter {
 			if !f(item) {
 				continue skipp
 			}
 		}
-		accounts = append(accounts, []string{
+		row := []string{
 			g.GID,
 			item.Name,
 			strings.Join([]string{"#", item.Tag}, ""),
 			item.CreatedOn.Format(prettyDateLayout),
-		})
+		}
 		if verbose {
-			accounts[i] = append(accounts[i],
+			row = append(row,
 				item.UpdatedOn.Format(prettyDateLayout),
-				item.Expiration(),
+				g.Expiration(item).String(),
+				item.fieldSummary(reveal),
 			)
 		}
+		accounts = append(accounts, row)
+	}
+	return accounts
+}
+
+// AccountInfo is the public view of an account as printed by the list command.
+// It never holds the account password and its field names follow the account
+type AccountInfo struct {
+	Group      string            `json:"group" yaml:"group"`
+	Name       string            `json:"name" yaml:"name"`
+	Tag        string            `json:"tag" yaml:"tag"`
+	Username   string            `json:"username,omitempty" yaml:"username,omitempty"`
+	URL        string            `json:"url,omitempty" yaml:"url,omitempty"`
+	Notes      string            `json:"notes,omitempty" yaml:"notes,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty" yaml:"fields,omitempty"`
+	CreatedOn  time.Time         `json:"created_on" yaml:"created_on"`
+	UpdatedOn  time.Time         `json:"updated_on" yaml:"updated_on"`
+	Expiration Expiration        `json:"expiration" yaml:"expiration"`
+}
+
+// Info builds the public view of all accounts of the group passing the filters.
+// Values of secret fields are masked unless reveal is true
+func (g group) Info(reveal bool, filter ...func(*account) bool) []AccountInfo {
+	accounts := make([]AccountInfo, 0, len(g.Accounts))
+
+skipp:
+	for _, item := range g.Accounts {
+		for _, f := range filter {
+			if !f(item) {
+				continue skipp
+			}
+		}
+		info := AccountInfo{
+			Group:      g.GID,
+			Name:       item.Name,
+			Tag:        item.Tag,
+			Username:   item.Username,
+			URL:        item.URL,
+			Notes:      item.Notes,
+			CreatedOn:  item.CreatedOn,
+			UpdatedOn:  item.UpdatedOn,
+			Expiration: g.Expiration(item),
+		}
+		if len(item.Fields) > 0 {
+			info.Fields = make(map[string]string, len(item.Fields))
+		}
+		for _, f := range item.Fields {
+			info.Fields[f.Key] = f.Value
+			if f.Secret && !reveal {
+				info.Fields[f.Key] = secretMask
+			}
+		}
+		accounts = append(accounts, info)
 	}
 	return accounts
 }
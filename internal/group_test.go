@@ -25,6 +25,14 @@ func TestCreateGoup(t *testing.T) {
 			name:   "test@group",
 			expect: ErrInvalidGroupNameSymbol,
 		},
+		{
+			name:   "test/group",
+			expect: ErrInvalidGroupNameSymbol,
+		},
+		{
+			name:   "../group",
+			expect: ErrInvalidGroupNameSymbol,
+		},
 	}
 	for _, tc := range tt {
 		_, err := NewGroup(tc.name)
@@ -153,3 +161,46 @@ func TestDeleteAccount(t *testing.T) {
 		}
 	}
 }
+
+func TestGroupInfo(t *testing.T) {
+	g := group{
+		GID: "test-group",
+		Accounts: []*account{
+			{
+				Name: "db",
+				Tag:  "prod",
+				Fields: []*field{
+					{Key: "host", Value: "localhost"},
+					{Key: "token", Value: "s3cret", Secret: true},
+				},
+			},
+			{
+				Name: "mail",
+				Tag:  "dev",
+			},
+		},
+	}
+
+	tt := []struct {
+		reveal bool
+		tag    string
+		count  int
+		token  string
+	}{
+		{reveal: false, tag: "", count: 2, token: secretMask},
+		{reveal: true, tag: "", count: 2, token: "s3cret"},
+		{reveal: false, tag: "prod", count: 1, token: secretMask},
+	}
+	for _, tc := range tt {
+		info := g.Info(tc.reveal, FilterByTag(tc.tag))
+		if len(info) != tc.count {
+			t.Fatalf("group.Info: want: %d accounts, have: %d", tc.count, len(info))
+		}
+		if info[0].Group != g.GID || info[0].Fields["host"] != "localhost" {
+			t.Fatalf("group.Info: want: %s/localhost, have: %s/%s", g.GID, info[0].Group, info[0].Fields["host"])
+		}
+		if info[0].Fields["token"] != tc.token {
+			t.Fatalf("group.Info: want: %q, have: %q", tc.token, info[0].Fields["token"])
+		}
+	}
+}
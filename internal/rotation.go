@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// states of the Expiration of an account password
+	ExpirationValid    = "valid"
+	ExpirationExpiring = "expiring"
+	ExpirationExpired  = "expired"
+	ExpirationNever    = "never"
+
+	// periodNever is the Period of passwords which never expire
+	periodNever = "never"
+	// expiringWindow is the time before the expiration
+	// in which a password is marked as expiring soon
+	expiringWindow = 14 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidPeriod = fmt.Errorf("rotation period must be a positive number with a unit d, w, m or y (like 30d or 6m) or never")
+
+	// DefaultRotation is the rotation period of accounts
+	// without a policy of their own, their tag or group
+	DefaultRotation = Period{Months: 6}
+)
+
+// Period is the time after which a password needs to be rotated.
+// It is written as a number with a unit (30d, 2w, 6m, 1y) or never
+type Period struct {
+	Years  int
+	Months int
+	Days   int
+	Never  bool
+}
+
+// ParsePeriod parses a period like 30d, 2w, 6m, 1y or never
+func ParsePeriod(s string) (Period, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == periodNever {
+		return Period{Never: true}, nil
+	}
+	if len(s) < 2 {
+		return Period{}, fmt.Errorf("%w: %q", ErrInvalidPeriod, s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return Period{}, fmt.Errorf("%w: %q", ErrInvalidPeriod, s)
+	}
+	switch s[len(s)-1] {
+	case 'd':
+		return Period{Days: n}, nil
+	case 'w':
+		return Period{Days: 7 * n}, nil
+	case 'm':
+		return Period{Months: n}, nil
+	case 'y':
+		return Period{Years: n}, nil
+	}
+	return Period{}, fmt.Errorf("%w: %q", ErrInvalidPeriod, s)
+}
+
+func (p Period) String() string {
+	switch {
+	case p.Never:
+		return periodNever
+	case p.Years > 0:
+		return strconv.Itoa(p.Years) + "y"
+	case p.Months > 0:
+		return strconv.Itoa(p.Months) + "m"
+	}
+	return strconv.Itoa(p.Days) + "d"
+}
+
+func (p Period) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+func (p *Period) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	period, err := ParsePeriod(s)
+	if err != nil {
+		return err
+	}
+	*p = period
+	return nil
+}
+
+// Expiration is the rotation status of an account password
+type Expiration struct {
+	// State is one of valid, expiring, expired or never
+	State string `json:"state" yaml:"state"`
+	// Policy is the rotation period applied to the account
+	Policy string `json:"policy" yaml:"policy"`
+	// ExpiresOn is nil if the password never expires
+	ExpiresOn *time.Time `json:"expires_on,omitempty" yaml:"expires_on,omitempty"`
+	// DaysLeft are the full days until the password expires. It is
+	// negative once the password expired and nil if it never expires
+	DaysLeft *int `json:"days_left,omitempty" yaml:"days_left,omitempty"`
+	// Left is the time until the password expires.
+	// It is negative once the password expired
+	Left time.Duration `json:"-" yaml:"-"`
+}
+
+// expirationAt computes the Expiration at now of a password
+// last changed at changed under the rotation period
+func expirationAt(changed time.Time, period Period, now time.Time) Expiration {
+	exp := Expiration{State: ExpirationNever, Policy: period.String()}
+	if period.Never {
+		return exp
+	}
+	expiresOn := changed.AddDate(period.Years, period.Months, period.Days)
+	exp.ExpiresOn = &expiresOn
+	exp.Left = expiresOn.Sub(now)
+	daysLeft := int(exp.Left.Hours()) / 24
+	exp.DaysLeft = &daysLeft
+	switch {
+	case exp.Left <= 0:
+		exp.State = ExpirationExpired
+	case exp.Left <= expiringWindow:
+		exp.State = ExpirationExpiring
+	default:
+		exp.State = ExpirationValid
+	}
+	return exp
+}
+
+// Expired reports whether the password needs to be rotated
+func (e Expiration) Expired() bool {
+	return e.State == ExpirationExpired
+}
+
+func (e Expiration) String() string {
+	if e.State == ExpirationNever {
+		return "never expires"
+	}
+	left := e.Left
+	if left < 0 {
+		left = -left
+	}
+	days := int(left.Hours()) / 24
+	hours := int(left.Hours()) % 24
+	switch e.State {
+	case ExpirationExpired:
+		return fmt.Sprintf("expired %v days %v hours ago", days, hours)
+	case ExpirationExpiring:
+		return fmt.Sprintf("expires in %v days %v hours", days, hours)
+	}
+	return fmt.Sprintf("valid for %v days %v hours", days, hours)
+}
+
+// rotationPolicy returns the rotation period of an account. A policy of
+// the account wins over the policy of its tag which wins over the policy
+// of the group. Without any DefaultRotation applies
+func (g group) rotationPolicy(a *account) Period {
+	if a.Rotation != nil {
+		return *a.Rotation
+	}
+	if period, ok := g.TagRotation[a.Tag]; ok && a.Tag != "" {
+		return period
+	}
+	if g.Rotation != nil {
+		return *g.Rotation
+	}
+	return DefaultRotation
+}
+
+// Expiration returns the rotation status of an account of the group
+func (g group) Expiration(a *account) Expiration {
+	return a.Expiration(g.rotationPolicy(a))
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParsePeriod(t *testing.T) {
+	tt := []struct {
+		in   string
+		want Period
+		err  error
+	}{
+		{in: "30d", want: Period{Days: 30}},
+		{in: "2w", want: Period{Days: 14}},
+		{in: "6M", want: Period{Months: 6}},
+		{in: "1y", want: Period{Years: 1}},
+		{in: "never", want: Period{Never: true}},
+		{in: "0d", err: ErrInvalidPeriod},
+		{in: "-3m", err: ErrInvalidPeriod},
+		{in: "30", err: ErrInvalidPeriod},
+		{in: "30h", err: ErrInvalidPeriod},
+		{in: "", err: ErrInvalidPeriod},
+	}
+
+	for _, tc := range tt {
+		have, err := ParsePeriod(tc.in)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("internal.ParsePeriod: %q: want: %v, have: %v", tc.in, tc.err, err)
+		}
+		if have != tc.want {
+			t.Fatalf("internal.ParsePeriod: %q: want: %+v, have: %+v", tc.in, tc.want, have)
+		}
+	}
+}
+
+func TestPeriodJSON(t *testing.T) {
+	g := group{
+		GID:         "test",
+		Rotation:    &Period{Years: 1},
+		TagRotation: map[string]Period{"prod": {Days: 30}, "archive": {Never: true}},
+	}
+	b, err := json.Marshal(g)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	var have group
+	if err := json.Unmarshal(b, &have); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if *have.Rotation != *g.Rotation || have.TagRotation["prod"] != g.TagRotation["prod"] || !have.TagRotation["archive"].Never {
+		t.Fatalf("json round trip: want: %s, have: %+v", b, have)
+	}
+}
+
+func TestExpirationAt(t *testing.T) {
+	changed := time.Date(2021, time.January, 31, 12, 0, 0, 0, time.UTC)
+	tt := []struct {
+		name   string
+		period Period
+		now    time.Time
+		state  string
+		left   time.Duration
+		str    string
+	}{
+		{
+			name:   "valid",
+			period: Period{Days: 30},
+			now:    changed.Add(24 * time.Hour),
+			state:  ExpirationValid,
+			left:   29 * 24 * time.Hour,
+			str:    "valid for 29 days 0 hours",
+		},
+		{
+			name:   "expiring soon",
+			period: Period{Days: 30},
+			now:    changed.AddDate(0, 0, 20).Add(5 * time.Hour),
+			state:  ExpirationExpiring,
+			left:   9*24*time.Hour + 19*time.Hour,
+			str:    "expires in 9 days 19 hours",
+		},
+		{
+			name:   "expired",
+			period: Period{Months: 6},
+			now:    changed.AddDate(0, 6, 3).Add(2 * time.Hour),
+			state:  ExpirationExpired,
+			left:   -(3*24*time.Hour + 2*time.Hour),
+			str:    "expired 3 days 2 hours ago",
+		},
+		{
+			name:   "never",
+			period: Period{Never: true},
+			now:    changed.AddDate(10, 0, 0),
+			state:  ExpirationNever,
+			str:    "never expires",
+		},
+	}
+
+	for _, tc := range tt {
+		exp := expirationAt(changed, tc.period, tc.now)
+		if exp.State != tc.state || exp.Left != tc.left || exp.String() != tc.str {
+			t.Fatalf("internal.expirationAt: %s: want: %s %v %q, have: %s %v %q", tc.name, tc.state, tc.left, tc.str, exp.State, exp.Left, exp.String())
+		}
+		if days := int(tc.left.Hours()) / 24; tc.state != ExpirationNever && (exp.DaysLeft == nil || *exp.DaysLeft != days) {
+			t.Fatalf("internal.expirationAt: %s: days left: want: %d, have: %v", tc.name, days, exp.DaysLeft)
+		}
+		if tc.state == ExpirationNever && exp.DaysLeft != nil {
+			t.Fatalf("internal.expirationAt: %s: days left: want: nil, have: %d", tc.name, *exp.DaysLeft)
+		}
+	}
+}
+
+func TestRotationPolicy(t *testing.T) {
+	g := group{
+		GID:         "test",
+		TagRotation: map[string]Period{"prod": {Days: 30}, "archive": {Never: true}},
+	}
+	tt := []struct {
+		name  string
+		group *Period
+		a     account
+		want  Period
+	}{
+		{name: "default", a: account{Tag: "misc"}, want: DefaultRotation},
+		{name: "group", group: &Period{Years: 1}, a: account{Tag: "misc"}, want: Period{Years: 1}},
+		{name: "tag", group: &Period{Years: 1}, a: account{Tag: "prod"}, want: Period{Days: 30}},
+		{name: "tag never", a: account{Tag: "archive"}, want: Period{Never: true}},
+		{name: "account", a: account{Tag: "prod", Rotation: &Period{Days: 7}}, want: Period{Days: 7}},
+	}
+
+	for _, tc := range tt {
+		g.Rotation = tc.group
+		if have := g.rotationPolicy(&tc.a); have != tc.want {
+			t.Fatalf("group.rotationPolicy: %s: want: %v, have: %v", tc.name, tc.want, have)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/KonstantinGasser/sherlock/security"
 )
@@ -12,13 +14,21 @@ const (
 	// querySplitPoint refers to the command line argument coming from the user
 	// in the form of group@account and the separator used for it
 	querySplitPoint = "@"
+	// fieldSplitPoint separates the optional field from the account
+	// in a query of the form group@account/field
+	fieldSplitPoint = "/"
+
+	// defaultLockTimeout is the time sherlock waits for a group
+	// locked by another process if no timeout is set
+	defaultLockTimeout = 5 * time.Second
 )
 
 var (
 	ErrNotSetup     = fmt.Errorf("sherlock needs to bee set-up first (use sherlock setup)")
 	ErrNoSuchGroup  = fmt.Errorf("provided group cannot be found (use sherlock add group)")
 	ErrWrongKey     = fmt.Errorf("wrong group key")
-	ErrInvalidQuery = fmt.Errorf("invalid query. Query should be %q", "group@account")
+	ErrCorrupted    = fmt.Errorf("vault corrupted or tampered")
+	ErrInvalidQuery = fmt.Errorf("invalid query. Query should be %q or %q", "group@account", "group@account/field")
 )
 
 // StateOption describes a function with can alter the state of
@@ -26,22 +36,34 @@ var (
 type StateOption func(g *group, acc string) error
 
 // OptAddAccount returns a StateOption allowing to append
-// an account to an group
-func OptAddAccount(account *account) StateOption {
+// an account to an group. Unless insecure the account password
+// must meet the minimum entropy of the group
+func OptAddAccount(account *account, insecure bool) StateOption {
 	return func(g *group, acc string) error {
+		if !insecure {
+			if err := g.secure(account.Password); err != nil {
+				return err
+			}
+		}
 		return g.append(account)
 	}
 }
 
 // OptAccPassword returns a StateOption to change
-// an account password
+// an account password. Unless insecure the password
+// must meet the minimum entropy of the group
 func OptAccPassword(password string, insecure bool) StateOption {
 	return func(g *group, acc string) error {
 		account, err := g.lookup(acc)
 		if err != nil {
 			return err
 		}
-		if err := account.update(updateFieldPassword(password, insecure)); err != nil {
+		if !insecure {
+			if err := g.secure(password); err != nil {
+				return err
+			}
+		}
+		if err := account.update(updateFieldPassword(password)); err != nil {
 			return err
 		}
 		return nil
@@ -81,6 +103,80 @@ func OptsAccTag(tag string) StateOption {
 	}
 }
 
+// OptAccField returns a StateOption which sets
+// a standard or custom field of an account
+func OptAccField(key, value string, secret bool) StateOption {
+	return func(g *group, acc string) error {
+		account, err := g.lookup(acc)
+		if err != nil {
+			return err
+		}
+		return account.update(updateFieldCustom(key, value, secret))
+	}
+}
+
+// OptAccDeleteField returns a StateOption which removes
+// a custom field or clears a standard field of an account
+func OptAccDeleteField(key string) StateOption {
+	return func(g *group, acc string) error {
+		account, err := g.lookup(acc)
+		if err != nil {
+			return err
+		}
+		return account.update(deleteFieldCustom(key))
+	}
+}
+
+// OptMinEntropy returns a StateOption setting the minimum entropy in
+// bits of the passwords of a group. Zero resets it to the default
+func OptMinEntropy(bits float64) StateOption {
+	return func(g *group, acc string) error {
+		if bits < 0 || bits > maxMinEntropy {
+			return ErrInvalidMinEntropy
+		}
+		g.MinEntropy = bits
+		return nil
+	}
+}
+
+// OptRotation returns a StateOption setting the rotation policy
+// of a group. Nil resets it to DefaultRotation
+func OptRotation(period *Period) StateOption {
+	return func(g *group, acc string) error {
+		g.Rotation = period
+		return nil
+	}
+}
+
+// OptTagRotation returns a StateOption setting the rotation policy
+// of the accounts of a group with the tag. Nil removes the policy
+func OptTagRotation(tag string, period *Period) StateOption {
+	return func(g *group, acc string) error {
+		if period == nil {
+			delete(g.TagRotation, tag)
+			return nil
+		}
+		if g.TagRotation == nil {
+			g.TagRotation = make(map[string]Period)
+		}
+		g.TagRotation[tag] = *period
+		return nil
+	}
+}
+
+// OptAccRotation returns a StateOption setting the rotation policy of
+// an account. Nil removes it so the policy of the tag or group applies
+func OptAccRotation(period *Period) StateOption {
+	return func(g *group, acc string) error {
+		account, err := g.lookup(acc)
+		if err != nil {
+			return err
+		}
+		account.Rotation = period
+		return nil
+	}
+}
+
 // OptAccDelete returns a StateOption deleting
 // an account if it exists
 func OptAccDelete() StateOption {
@@ -99,11 +195,21 @@ type FileSystem interface {
 	ReadGroupVault(group string) ([]byte, error)
 	Delete(ctx context.Context, gid string) error
 	Write(ctx context.Context, gid string, data []byte) error
+	// RemoveBackup removes the copy of the previous vault kept by Write
+	RemoveBackup(gid string) error
 	ReadRegisteredGroups() ([]string, error)
+	ReadKDFParams() ([]byte, error)
+	WriteKDFParams(data []byte) error
+	// Lock acquires a lock on a group which is exclusive across processes.
+	// It gives up once the timeout passed
+	Lock(ctx context.Context, gid string, timeout time.Duration) error
+	// Unlock releases a lock acquired with Lock
+	Unlock(gid string) error
 }
 
 type Sherlock struct {
-	fileSystem FileSystem
+	fileSystem  FileSystem
+	lockTimeout time.Duration
 }
 
 // New return new Sherlock instance
@@ -115,7 +221,7 @@ func NewSherlock(fs FileSystem) *Sherlock {
 
 // IsSetUp verifies that sherlock is operational
 //
-// to be operational there must be a folder $HOME/.sherlock/group
+// to be operational there must be a folder {root}/groups (by default $HOME/.sherlock)
 // with the default group and an encrypted default vault for which
 // the user has set a group password.
 func (sh Sherlock) IsSetUp() error {
@@ -131,9 +237,13 @@ func (sh Sherlock) IsSetUp() error {
 // Setup sets the sherlock environment up
 //
 // the env requires to have an default group with an encrypted default vault
-// sitting in $HOME/.sherlock/group.
+// sitting in {root}/groups (by default $HOME/.sherlock).
 func (sh *Sherlock) Setup(groupKey string) error {
-	vault, err := security.InitWithDefault(groupKey, newDefaultGroup())
+	params, err := sh.KDFParams()
+	if err != nil {
+		return err
+	}
+	vault, err := security.InitWithDefault(groupKey, newDefaultGroup(), params)
 	if err != nil {
 		return err
 	}
@@ -147,15 +257,19 @@ func (sh *Sherlock) Setup(groupKey string) error {
 // DeleteGroup irreversible deletes a group from sherlock
 // and the underlying file-system
 func (sh *Sherlock) DeleteGroup(ctx context.Context, gid string) error {
-	return sh.fileSystem.Delete(ctx, gid)
+	// the lock file is removed along with the group
+	return sh.locked(ctx, gid, func() error {
+		return sh.fileSystem.Delete(ctx, gid)
+	})
 }
 
 // SetupGroup creates a new group in sherlock
 //
 // a group creation will be rejected if the GID already
 // exits, or the groupKey is to weak (if !insecure). The created group
-// will be initialized with an encrypted default vault.
-func (sh Sherlock) SetupGroup(name string, groupKey string, insecure bool) error {
+// will be initialized with an encrypted default vault to which the
+// opts (like OptMinEntropy) are applied.
+func (sh Sherlock) SetupGroup(name string, groupKey string, insecure bool, opts ...StateOption) error {
 	if err := sh.GroupExists(name); err != nil {
 		return err
 	}
@@ -163,13 +277,22 @@ func (sh Sherlock) SetupGroup(name string, groupKey string, insecure bool) error
 	if err != nil {
 		return err
 	}
+	for _, opt := range opts {
+		if err := opt(group, ""); err != nil {
+			return err
+		}
+	}
 	if !insecure {
 		// check password strength for group key
 		if err := group.secure(groupKey); err != nil {
 			return err
 		}
 	}
-	vault, err := security.InitWithDefault(groupKey, group)
+	params, err := sh.KDFParams()
+	if err != nil {
+		return err
+	}
+	vault, err := security.InitWithDefault(groupKey, group, params)
 	if err != nil {
 		return err
 	}
@@ -196,7 +319,7 @@ func (sh *Sherlock) CheckGroupKey(ctx context.Context, query, groupKey string) e
 	}
 	var g group
 	if err := security.Decrypt(bytes, groupKey, &g); err != nil {
-		return ErrWrongKey
+		return decryptionErr(err)
 	}
 	return nil
 }
@@ -217,6 +340,59 @@ func (sh Sherlock) GetAccount(query string, groupKey string) (*account, error) {
 	return group.lookup(name)
 }
 
+// GetField looks up a field of the requested account
+//
+// the lookup is performed through the query (group@account/field). If the
+// query has no field the account password is returned.
+func (sh Sherlock) GetField(query string, groupKey string) (string, error) {
+	gid, name, field, err := SplitFieldQuery(query)
+	if err != nil {
+		return "", err
+	}
+	account, err := sh.GetAccount(gid+querySplitPoint+name, groupKey)
+	if err != nil {
+		return "", err
+	}
+	return account.Field(field)
+}
+
+// GetFields looks up the fields of several queries (group@account/field) and
+// returns the values in the order of the queries
+//
+// each group is loaded once. The key of a group is requested through groupKey
+// the first time a query of the group is resolved
+func (sh Sherlock) GetFields(queries []string, groupKey func(gid string) (string, error)) ([]string, error) {
+	groups := make(map[string]*group)
+	values := make([]string, 0, len(queries))
+	for _, query := range queries {
+		gid, name, field, err := SplitFieldQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", query, err)
+		}
+		g, ok := groups[gid]
+		if !ok {
+			key, err := groupKey(gid)
+			if err != nil {
+				return nil, err
+			}
+			if g, err = sh.LoadGroup(gid, key); err != nil {
+				return nil, fmt.Errorf("%s: %w", gid, err)
+			}
+			groups[gid] = g
+		}
+		account, err := g.lookup(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", query, err)
+		}
+		value, err := account.Field(field)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", query, err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
 // UpdateState executes the passed in StateOption to perform state changes on a group
 //
 // it allows to modify a group/account (adding accounts, changing account) through the passed StateOption.
@@ -226,20 +402,90 @@ func (sh Sherlock) UpdateState(ctx context.Context, query, groupKey string, opt
 		return err
 	}
 
-	group, err := sh.LoadGroup(gid, groupKey)
-	if err != nil {
-		return err
+	return sh.locked(ctx, gid, func() error {
+		group, err := sh.LoadGroup(gid, groupKey)
+		if err != nil {
+			return err
+		}
+		if err := opt(group, account); err != nil {
+			return err
+		}
+		return sh.writeGroup(ctx, gid, groupKey, group)
+	})
+}
+
+// UpdateGroup executes the passed in StateOptions on a group
+//
+// other than UpdateState it does not refer to an account and
+// allows changing the settings of a group (see OptMinEntropy).
+func (sh Sherlock) UpdateGroup(ctx context.Context, gid, groupKey string, opts ...StateOption) error {
+	return sh.locked(ctx, gid, func() error {
+		group, err := sh.LoadGroup(gid, groupKey)
+		if err != nil {
+			return err
+		}
+		for _, opt := range opts {
+			if err := opt(group, ""); err != nil {
+				return err
+			}
+		}
+		return sh.writeGroup(ctx, gid, groupKey, group)
+	})
+}
+
+// SetLockTimeout sets the time sherlock waits for a group which
+// is locked by another process before giving up
+func (sh *Sherlock) SetLockTimeout(timeout time.Duration) {
+	sh.lockTimeout = timeout
+}
+
+// locked runs fn while holding the lock of the group
+//
+// any read-modify-write of a group must be done through locked so
+// concurrent sherlock processes do not overwrite each others changes.
+func (sh Sherlock) locked(ctx context.Context, gid string, fn func() error) error {
+	timeout := sh.lockTimeout
+	if timeout <= 0 {
+		timeout = defaultLockTimeout
 	}
-	if err := opt(group, account); err != nil {
+	if err := sh.fileSystem.Lock(ctx, gid, timeout); err != nil {
 		return err
 	}
-	return sh.writeGroup(ctx, gid, groupKey, group)
+	defer func() { _ = sh.fileSystem.Unlock(gid) }()
+	return fn()
+}
+
+// ChangeGroupKey re-encrypts a group under a new group key
+//
+// the current groupKey must be able to decrypt the group. The new key will be
+// rejected if it is to weak (if !insecure).
+func (sh Sherlock) ChangeGroupKey(ctx context.Context, gid, groupKey, newGroupKey string, insecure bool) error {
+	return sh.locked(ctx, gid, func() error {
+		group, err := sh.LoadGroup(gid, groupKey)
+		if err != nil {
+			return err
+		}
+		if !insecure {
+			if err := group.secure(newGroupKey); err != nil {
+				return err
+			}
+		}
+		if err := sh.writeGroup(ctx, gid, newGroupKey, group); err != nil {
+			return err
+		}
+		// the backup of the previous vault is still encrypted
+		// under the old (maybe compromised) key
+		return sh.fileSystem.RemoveBackup(gid)
+	})
 }
 
 // LoadGroup loads a group
 //
 // it wraps the reading of the group and the decryption
-// functions together
+// functions together. Legacy vaults are decrypted with the
+// legacy scheme and stored in the current format on the next write.
+// Accounts created before password changes were tracked are
+// seeded as well (see account.seedRotatedOn)
 func (sh Sherlock) LoadGroup(gid string, groupKey string) (*group, error) {
 	bytes, err := sh.fileSystem.ReadGroupVault(gid)
 	if err != nil {
@@ -247,11 +493,76 @@ func (sh Sherlock) LoadGroup(gid string, groupKey string) (*group, error) {
 	}
 	var g group
 	if err := security.Decrypt(bytes, groupKey, &g); err != nil {
-		return nil, ErrWrongKey
+		return nil, decryptionErr(err)
+	}
+	for _, acc := range g.Accounts {
+		acc.seedRotatedOn()
 	}
 	return &g, nil
 }
 
+// VaultKey derives the key of the group vault from the group key. The key
+// unlocks the group like the group key until the group is written with the
+// group key again (see security.DeriveVaultKey)
+func (sh Sherlock) VaultKey(gid string, groupKey string) (string, error) {
+	bytes, err := sh.fileSystem.ReadGroupVault(gid)
+	if err != nil {
+		return "", err
+	}
+	key, err := security.DeriveVaultKey(bytes, groupKey)
+	if err != nil {
+		return "", decryptionErr(err)
+	}
+	return key, nil
+}
+
+// decryptionErr maps the errors of security.Decrypt to the errors
+// presented to the user
+func decryptionErr(err error) error {
+	switch err {
+	case security.ErrWrongKey:
+		return ErrWrongKey
+	case security.ErrCorruptedVault:
+		return ErrCorrupted
+	default:
+		return err
+	}
+}
+
+// IsLegacyGroup reports whether the vault of a group is still
+// stored in the legacy format (see security.IsLegacy)
+func (sh Sherlock) IsLegacyGroup(gid string) (bool, error) {
+	bytes, err := sh.fileSystem.ReadGroupVault(gid)
+	if err != nil {
+		return false, err
+	}
+	return security.IsLegacy(bytes), nil
+}
+
+// MigrateGroup re-encrypts a legacy vault in the current format
+//
+// groups already stored in the current format are left untouched
+// in which case false is returned.
+func (sh Sherlock) MigrateGroup(ctx context.Context, gid string, groupKey string) (bool, error) {
+	var upgraded bool
+	err := sh.locked(ctx, gid, func() error {
+		legacy, err := sh.IsLegacyGroup(gid)
+		if err != nil || !legacy {
+			return err
+		}
+		group, err := sh.LoadGroup(gid, groupKey)
+		if err != nil {
+			return err
+		}
+		if err := sh.writeGroup(ctx, gid, groupKey, group); err != nil {
+			return err
+		}
+		upgraded = true
+		return nil
+	})
+	return upgraded, err
+}
+
 // writeGroup saves a group in sherlock
 //
 // it wraps the encryption and the writing of a group together
@@ -261,13 +572,48 @@ func (sh Sherlock) writeGroup(ctx context.Context, gid string, groupKey string,
 	if err != nil {
 		return err
 	}
-	encrypted, err := security.Encrypt(serialized, groupKey)
+	params, err := sh.KDFParams()
+	if err != nil {
+		return err
+	}
+	encrypted, err := security.Encrypt(serialized, groupKey, params)
 	if err != nil {
 		return err
 	}
 	return sh.fileSystem.Write(ctx, gid, encrypted)
 }
 
+// KDFParams returns the key derivation parameters used whenever
+// a vault is written
+//
+// if no parameters have been stored (see SaveKDFParams) the
+// security.DefaultKDFParams are used.
+func (sh Sherlock) KDFParams() (security.KDFParams, error) {
+	data, err := sh.fileSystem.ReadKDFParams()
+	if err != nil {
+		return security.KDFParams{}, err
+	}
+	if data == nil {
+		return security.DefaultKDFParams, nil
+	}
+	var params security.KDFParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		return security.KDFParams{}, err
+	}
+	return params, nil
+}
+
+// SaveKDFParams stores the key derivation parameters for all
+// future vault writes. Existing vaults keep their parameters until
+// they are written the next time
+func (sh Sherlock) SaveKDFParams(params security.KDFParams) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return sh.fileSystem.WriteKDFParams(data)
+}
+
 // SplitQuery separates the user query into it pieces (group, account)
 //
 // quires not following the format will result in a ErrInvalidQuery error
@@ -280,6 +626,26 @@ func SplitQuery(query string) (string, string, error) {
 	return set[0], set[1], nil
 }
 
+// SplitFieldQuery separates a user query with an optional field into its
+// pieces (group, account, field)
+//
+// format: group@account or group@account/field. The field is empty if
+// the query does not name a field
+func SplitFieldQuery(query string) (string, string, string, error) {
+	var field string
+	if i := strings.LastIndex(query, fieldSplitPoint); i != -1 {
+		query, field = query[:i], query[i+len(fieldSplitPoint):]
+		if field == "" {
+			return "", "", "", ErrInvalidQuery
+		}
+	}
+	gid, account, err := SplitQuery(query)
+	if err != nil {
+		return "", "", "", err
+	}
+	return gid, account, field, nil
+}
+
 // func NameValidation(name string) bool {
 // 	return !strings.Contains(name, querySplitPoint)
 // }
@@ -1,6 +1,16 @@
 package internal
 
 import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
 	"testing"
 
 	"github.com/KonstantinGasser/sherlock/fs"
@@ -9,7 +19,7 @@ import (
 
 func memLock() *Sherlock {
 	return &Sherlock{
-		fileSystem: fs.New(afero.NewMemMapFs()),
+		fileSystem: fs.New(afero.NewMemMapFs(), fs.DefaultRoot()),
 	}
 }
 
@@ -80,16 +90,115 @@ func TestOptAccPassword(t *testing.T) {
 			insecure: false,
 			ok:       true,
 		},
+		{
+			g: group{
+				GID: "test4",
+				Accounts: []*account{
+					{
+						Name:     "test-acc4",
+						Password: "hello-world",
+					},
+				},
+			},
+			accName:  "test-acc4",
+			newPass:  "hello-world",
+			insecure: true,
+			ok:       false,
+		},
 	}
 
 	for _, tc := range tt {
 		err := OptAccPassword(tc.newPass, tc.insecure)(&tc.g, tc.accName)
+		if !tc.ok && !tc.g.Accounts[0].RotatedOn.IsZero() {
+			t.Fatalf("internal.OptAccPassword: want: not rotated, have: rotated on %v", tc.g.Accounts[0].RotatedOn)
+		}
 		if (err != nil && tc.ok) || (err == nil && !tc.ok) {
 			t.Fatalf("internal.OptAccPassword: want:updated==%v, have:err==%v", tc.ok, err)
 		}
 		if tc.ok && tc.newPass != tc.g.Accounts[0].Password {
 			t.Fatalf("internal.OptAccPassword: want: %s, have: %s", tc.newPass, tc.g.Accounts[0].Password)
 		}
+		if tc.ok && tc.g.Accounts[0].PreviousPassword != "hello-world" {
+			t.Fatalf("internal.OptAccPassword: want previous: %s, have: %s", "hello-world", tc.g.Accounts[0].PreviousPassword)
+		}
+	}
+}
+
+func TestOptAddAccount(t *testing.T) {
+	tt := []struct {
+		name       string
+		minEntropy float64
+		password   string
+		insecure   bool
+		ok         bool
+	}{
+		{
+			name:     "weak password",
+			password: "helloworld",
+			ok:       false,
+		},
+		{
+			name:     "weak password allowed by insecure",
+			password: "helloworld",
+			insecure: true,
+			ok:       true,
+		},
+		{
+			name:     "strong password",
+			password: "fsdf$35dfg0-43563sdf34",
+			ok:       true,
+		},
+		{
+			name:       "group requires more entropy",
+			minEntropy: 128,
+			password:   "fsdf$35dfg0-43563sdf34",
+			ok:         false,
+		},
+		{
+			name:       "group requires less entropy",
+			minEntropy: 20,
+			password:   "helloworld",
+			ok:         true,
+		},
+	}
+
+	for _, tc := range tt {
+		g := group{GID: "test", MinEntropy: tc.minEntropy}
+		a, err := NewAccount("test@acc", tc.password, "")
+		if err != nil {
+			t.Fatalf("internal.NewAccount: want: nil, have: %v", err)
+		}
+		err = OptAddAccount(a, tc.insecure)(&g, "acc")
+		if (err != nil && tc.ok) || (err == nil && !tc.ok) {
+			t.Fatalf("internal.OptAddAccount: %s: want:added==%v, have:err==%v", tc.name, tc.ok, err)
+		}
+		if err != nil && !errors.Is(err, ErrInsecurePassword) {
+			t.Fatalf("internal.OptAddAccount: %s: want: %v, have: %v", tc.name, ErrInsecurePassword, err)
+		}
+	}
+}
+
+func TestOptMinEntropy(t *testing.T) {
+	tt := []struct {
+		bits float64
+		want float64
+		ok   bool
+	}{
+		{bits: 80, want: 80, ok: true},
+		{bits: 0, want: 60, ok: true},
+		{bits: -1, ok: false},
+		{bits: 512, ok: false},
+	}
+
+	for _, tc := range tt {
+		g := group{GID: "test"}
+		err := OptMinEntropy(tc.bits)(&g, "")
+		if (err != nil && tc.ok) || (err == nil && !tc.ok) {
+			t.Fatalf("internal.OptMinEntropy: %v: want:set==%v, have:err==%v", tc.bits, tc.ok, err)
+		}
+		if tc.ok && g.RequiredEntropy() != tc.want {
+			t.Fatalf("group.RequiredEntropy: want: %v, have: %v", tc.want, g.RequiredEntropy())
+		}
 	}
 }
 
@@ -126,13 +235,69 @@ func TestOptAccName(t *testing.T) {
 			newName: "test-acc2_2",
 			err:     ErrNoSuchAccount,
 		},
+		{
+			g: group{
+				GID: "test3",
+				Accounts: []*account{
+					{
+						Name: "test-acc3",
+					},
+				},
+			},
+			accName: "test-acc3",
+			newName: "test acc3",
+			err:     ErrInvalidAccountName,
+		},
+		{
+			g: group{
+				GID: "test3",
+				Accounts: []*account{
+					{
+						Name: "test-acc3",
+					},
+				},
+			},
+			accName: "test-acc3",
+			newName: "test@acc3",
+			err:     ErrInvalidAccountNameSymbol,
+		},
+		{
+			g: group{
+				GID: "test3",
+				Accounts: []*account{
+					{
+						Name: "test-acc3",
+					},
+				},
+			},
+			accName: "test-acc3",
+			newName: "test/acc3",
+			err:     ErrInvalidAccountNameSymbol,
+		},
+		{
+			g: group{
+				GID: "test3",
+				Accounts: []*account{
+					{
+						Name: "test-acc3",
+					},
+				},
+			},
+			accName: "test-acc3",
+			newName: " ",
+			err:     ErrMissingValues,
+		},
 	}
 
 	for _, tc := range tt {
+		before := tc.g.Accounts[0].Name
 		err := OptAccName(tc.newName)(&tc.g, tc.accName)
 		if err != tc.err {
 			t.Fatalf("internal.OptAccName: want: %s, have: %s", tc.err, err)
 		}
+		if err != nil && tc.g.Accounts[0].Name != before {
+			t.Fatalf("internal.OptAccName: want: %s, have: %s", before, tc.g.Accounts[0].Name)
+		}
 		if err == nil && (tc.newName != tc.g.Accounts[0].Name) {
 			t.Fatalf("internal.OptAccName: want: %s, have: %s", tc.newName, tc.g.Accounts[0].Name)
 		}
@@ -177,3 +342,247 @@ func TestOptAccDelete(t *testing.T) {
 
 	}
 }
+
+func TestLoadGroupCorrupted(t *testing.T) {
+	sh := memLock()
+	if err := sh.SetupGroup("test-group", "test-group-key", true); err != nil {
+		t.Fatalf("sherlock.SetupGroup: want: nil, have: %v", err)
+	}
+	vault, err := sh.fileSystem.ReadGroupVault("test-group")
+	if err != nil {
+		t.Fatalf("fs.ReadGroupVault: want: nil, have: %v", err)
+	}
+
+	if _, err := sh.LoadGroup("test-group", "wrong-key"); err != ErrWrongKey {
+		t.Fatalf("sherlock.LoadGroup: want: %v, have: %v", ErrWrongKey, err)
+	}
+
+	vault[len(vault)-1] ^= 0x01
+	if err := sh.fileSystem.Write(context.Background(), "test-group", vault); err != nil {
+		t.Fatalf("fs.Write: want: nil, have: %v", err)
+	}
+	if _, err := sh.LoadGroup("test-group", "test-group-key"); err != ErrCorrupted {
+		t.Fatalf("sherlock.LoadGroup: want: %v, have: %v", ErrCorrupted, err)
+	}
+}
+
+func TestChangeGroupKey(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	sh := &Sherlock{fileSystem: fs.New(mem, fs.DefaultRoot())}
+	if err := sh.SetupGroup("test-group", "test-group-key", true); err != nil {
+		t.Fatalf("sherlock.SetupGroup: want: nil, have: %v", err)
+	}
+
+	tt := []struct {
+		name     string
+		groupKey string
+		newKey   string
+		insecure bool
+		ok       bool
+	}{
+		{
+			name:     "wrong current key",
+			groupKey: "wrong-key",
+			newKey:   "$wsert-2w345_2@34#!0?",
+			ok:       false,
+		},
+		{
+			name:     "insecure new key",
+			groupKey: "test-group-key",
+			newKey:   "helloworld",
+			insecure: false,
+			ok:       false,
+		},
+		{
+			name:     "secure new key",
+			groupKey: "test-group-key",
+			newKey:   "$wsert-2w345_2@34#!0?",
+			ok:       true,
+		},
+	}
+	for _, tc := range tt {
+		err := sh.ChangeGroupKey(context.Background(), "test-group", tc.groupKey, tc.newKey, tc.insecure)
+		if (err != nil && tc.ok) || (err == nil && !tc.ok) {
+			t.Fatalf("sherlock.ChangeGroupKey: %s: want:changed==%v, have:err==%v", tc.name, tc.ok, err)
+		}
+	}
+
+	if _, err := sh.LoadGroup("test-group", "test-group-key"); err != ErrWrongKey {
+		t.Fatalf("sherlock.LoadGroup: old key: want: %v, have: %v", ErrWrongKey, err)
+	}
+	if _, err := sh.LoadGroup("test-group", "$wsert-2w345_2@34#!0?"); err != nil {
+		t.Fatalf("sherlock.LoadGroup: new key: want: nil, have: %v", err)
+	}
+
+	// no backup under the old key must be left
+	backup := filepath.Join(fs.DefaultRoot(), "groups", "test-group", ".vault.bak")
+	if ok, err := afero.Exists(mem, backup); ok || err != nil {
+		t.Fatalf("sherlock.ChangeGroupKey: want: no backup, have: exists==%v (err: %v)", ok, err)
+	}
+}
+
+func TestMigrateGroup(t *testing.T) {
+	sh := memLock()
+	if err := sh.SetupGroup("legacy-group", "legacy-group-key", true); err != nil {
+		t.Fatalf("sherlock.SetupGroup: want: nil, have: %v", err)
+	}
+	legacy := legacyVault(t, "legacy-group-key", group{
+		GID:      "legacy-group",
+		Accounts: []*account{{Name: "test-acc"}},
+	})
+	if err := sh.fileSystem.Write(context.Background(), "legacy-group", legacy); err != nil {
+		t.Fatalf("fs.Write: want: nil, have: %v", err)
+	}
+
+	// legacy vaults can still be loaded
+	g, err := sh.LoadGroup("legacy-group", "legacy-group-key")
+	if err != nil {
+		t.Fatalf("sherlock.LoadGroup: want: nil, have: %v", err)
+	}
+	if !g.exists("test-acc") {
+		t.Fatalf("sherlock.LoadGroup: want: account %q, have: %v", "test-acc", g.Accounts)
+	}
+
+	if _, err := sh.MigrateGroup(context.Background(), "legacy-group", "wrong-key"); err != ErrWrongKey {
+		t.Fatalf("sherlock.MigrateGroup: want: %v, have: %v", ErrWrongKey, err)
+	}
+	upgraded, err := sh.MigrateGroup(context.Background(), "legacy-group", "legacy-group-key")
+	if err != nil || !upgraded {
+		t.Fatalf("sherlock.MigrateGroup: want: upgraded==true, have: upgraded==%v, err==%v", upgraded, err)
+	}
+	if legacy, _ := sh.IsLegacyGroup("legacy-group"); legacy {
+		t.Fatalf("sherlock.IsLegacyGroup: want: false, have: true")
+	}
+	upgraded, err = sh.MigrateGroup(context.Background(), "legacy-group", "legacy-group-key")
+	if err != nil || upgraded {
+		t.Fatalf("sherlock.MigrateGroup: want: upgraded==false, have: upgraded==%v, err==%v", upgraded, err)
+	}
+	if _, err := sh.LoadGroup("legacy-group", "legacy-group-key"); err != nil {
+		t.Fatalf("sherlock.LoadGroup: want: nil, have: %v", err)
+	}
+}
+
+// legacyVault encrypts the group the way vaults were written before
+// they carried a header
+func legacyVault(t *testing.T, key string, g group) []byte {
+	b, err := json.Marshal(g)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher([]byte(hex.EncodeToString(sum[:]))[:16])
+	if err != nil {
+		t.Fatalf("aes.NewCipher: %v", err)
+	}
+	encrypted := make([]byte, aes.BlockSize+len(b))
+	iv := encrypted[:aes.BlockSize]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(encrypted[aes.BlockSize:], b)
+	return encrypted
+}
+
+func TestSplitFieldQuery(t *testing.T) {
+	tt := []struct {
+		query   string
+		gid     string
+		account string
+		field   string
+		err     error
+	}{
+		{query: "work@db", gid: "work", account: "db", field: "", err: nil},
+		{query: "work@db/username", gid: "work", account: "db", field: "username", err: nil},
+		{query: "work@db/", err: ErrInvalidQuery},
+		{query: "workdb/username", err: ErrInvalidQuery},
+	}
+	for _, tc := range tt {
+		gid, account, field, err := SplitFieldQuery(tc.query)
+		if err != tc.err {
+			t.Fatalf("internal.SplitFieldQuery: %q: want: %v, have: %v", tc.query, tc.err, err)
+		}
+		if gid != tc.gid || account != tc.account || field != tc.field {
+			t.Fatalf("internal.SplitFieldQuery: %q: want: (%q, %q, %q), have: (%q, %q, %q)",
+				tc.query, tc.gid, tc.account, tc.field, gid, account, field)
+		}
+	}
+}
+
+func TestGetFields(t *testing.T) {
+	sh := memLock()
+	groups := map[string]string{"work": "work-group-key", "home": "home-group-key"}
+	for gid, key := range groups {
+		if err := sh.SetupGroup(gid, key, true); err != nil {
+			t.Fatalf("sherlock.SetupGroup: want: nil, have: %v", err)
+		}
+		account, err := NewAccount(gid+"@db", gid+"-db-password", "")
+		if err != nil {
+			t.Fatalf("internal.NewAccount: want: nil, have: %v", err)
+		}
+		if err := account.SetField("username", gid+"-user", false); err != nil {
+			t.Fatalf("account.SetField: want: nil, have: %v", err)
+		}
+		if err := sh.UpdateState(context.Background(), gid+"@db", key, OptAddAccount(account, true)); err != nil {
+			t.Fatalf("sherlock.UpdateState: want: nil, have: %v", err)
+		}
+	}
+
+	var requested []string
+	groupKey := func(gid string) (string, error) {
+		requested = append(requested, gid)
+		return groups[gid], nil
+	}
+
+	values, err := sh.GetFields([]string{"work@db", "home@db/username", "work@db/username"}, groupKey)
+	if err != nil {
+		t.Fatalf("sherlock.GetFields: want: nil, have: %v", err)
+	}
+	want := []string{"work-db-password", "home-user", "work-user"}
+	for i := range want {
+		if values[i] != want[i] {
+			t.Fatalf("sherlock.GetFields: want: %q, have: %q", want[i], values[i])
+		}
+	}
+	if len(requested) != 2 {
+		t.Fatalf("sherlock.GetFields: want: one key request per group, have: %v", requested)
+	}
+
+	if _, err := sh.GetFields([]string{"work@missing"}, groupKey); !errors.Is(err, ErrNoSuchAccount) {
+		t.Fatalf("sherlock.GetFields: want: %v, have: %v", ErrNoSuchAccount, err)
+	}
+}
+
+func TestVaultKey(t *testing.T) {
+	sh := memLock()
+	if err := sh.SetupGroup("work", "work-group-key", true); err != nil {
+		t.Fatalf("sherlock.SetupGroup: want: nil, have: %v", err)
+	}
+	if _, err := sh.VaultKey("work", "wrong-key"); err != ErrWrongKey {
+		t.Fatalf("sherlock.VaultKey: want: %v, have: %v", ErrWrongKey, err)
+	}
+	key, err := sh.VaultKey("work", "work-group-key")
+	if err != nil {
+		t.Fatalf("sherlock.VaultKey: want: nil, have: %v", err)
+	}
+
+	account, err := NewAccount("work@db", "db-password", "")
+	if err != nil {
+		t.Fatalf("internal.NewAccount: want: nil, have: %v", err)
+	}
+	if err := sh.UpdateState(context.Background(), "work@db", key, OptAddAccount(account, true)); err != nil {
+		t.Fatalf("sherlock.UpdateState: vault key: want: nil, have: %v", err)
+	}
+	for _, k := range []string{key, "work-group-key"} {
+		if _, err := sh.GetAccount("work@db", k); err != nil {
+			t.Fatalf("sherlock.GetAccount: want: nil, have: %v", err)
+		}
+	}
+
+	// writing with the group key invalidates the vault key
+	if err := sh.UpdateState(context.Background(), "work@db", "work-group-key", OptsAccTag("db")); err != nil {
+		t.Fatalf("sherlock.UpdateState: want: nil, have: %v", err)
+	}
+	if _, err := sh.LoadGroup("work", key); err != ErrWrongKey {
+		t.Fatalf("sherlock.LoadGroup: stale vault key: want: %v, have: %v", ErrWrongKey, err)
+	}
+}
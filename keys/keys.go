@@ -0,0 +1,177 @@
+// Package keys provides group keys from non-interactive sources like
+// files, file descriptors or environment variables so sherlock can be
+// used from scripts and pipelines
+package keys
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// EnvPrefix is the prefix of the environment variables holding
+// group keys => SHERLOCK_KEY_<GROUP>
+const EnvPrefix = "SHERLOCK_KEY_"
+
+var (
+	ErrEmptyKey  = fmt.Errorf("key source is empty")
+	ErrInvalidFD = fmt.Errorf("invalid file descriptor")
+)
+
+// Provider supplies group keys without prompting the user
+type Provider interface {
+	// Key returns the key of the group. ok is false if the
+	// provider does not know the key of the group
+	Key(gid string) (key string, ok bool, err error)
+}
+
+// Chain asks each provider in order for a group key
+// and returns the first key found
+type Chain []Provider
+
+func (c Chain) Key(gid string) (string, bool, error) {
+	for _, p := range c {
+		key, ok, err := p.Key(gid)
+		if err != nil || ok {
+			return key, ok, err
+		}
+	}
+	return "", false, nil
+}
+
+// reader provides the first line of a source as key for any group.
+// The source is only read once since pipes and file descriptors
+// can not be read twice
+type reader struct {
+	name string
+	open func() (io.ReadCloser, error)
+
+	once sync.Once
+	key  string
+	err  error
+}
+
+func (r *reader) Key(gid string) (string, bool, error) {
+	r.once.Do(func() {
+		r.key, r.err = r.read()
+	})
+	if r.err != nil {
+		return "", false, r.err
+	}
+	return r.key, true, nil
+}
+
+func (r *reader) read() (string, error) {
+	rc, err := r.open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	line, err := readLine(rc)
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimSuffix(line, "\r")
+	if key == "" {
+		return "", fmt.Errorf("%s: %w", r.name, ErrEmptyKey)
+	}
+	return key, nil
+}
+
+// readLine reads up to the first newline. The source is read byte by byte
+// so following prompts reading from the same source (stdin) do not lose input
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF {
+			return string(line), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// File provides the first line of the file as key
+func File(path string) Provider {
+	return &reader{
+		name: path,
+		open: func() (io.ReadCloser, error) {
+			return os.Open(path)
+		},
+	}
+}
+
+// FD provides the first line read from the
+// open file descriptor as key
+func FD(fd int) Provider {
+	name := fmt.Sprintf("fd %d", fd)
+	return &reader{
+		name: name,
+		open: func() (io.ReadCloser, error) {
+			if fd < 0 {
+				return nil, fmt.Errorf("%w: %d", ErrInvalidFD, fd)
+			}
+			f := os.NewFile(uintptr(fd), name)
+			if f == nil {
+				return nil, fmt.Errorf("%w: %d", ErrInvalidFD, fd)
+			}
+			return f, nil
+		},
+	}
+}
+
+// Stdin provides the first line read from stdin as key
+func Stdin() Provider {
+	return &reader{
+		name: "stdin",
+		open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(os.Stdin), nil
+		},
+	}
+}
+
+// env provides the group key from SHERLOCK_KEY_<GROUP>
+type env struct {
+	lookup func(string) (string, bool)
+}
+
+// Env provides the key of a group from the environment variable
+// SHERLOCK_KEY_<GROUP> (see EnvName)
+func Env(lookup func(string) (string, bool)) Provider {
+	return env{lookup: lookup}
+}
+
+func (e env) Key(gid string) (string, bool, error) {
+	key, ok := e.lookup(EnvName(gid))
+	if !ok || key == "" {
+		return "", false, nil
+	}
+	return key, true, nil
+}
+
+// EnvName returns the environment variable holding the key of a group.
+// The group name is upper cased and any character other than letters
+// and digits is replaced by "_" => my-group: SHERLOCK_KEY_MY_GROUP
+func EnvName(gid string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, gid)
+	return EnvPrefix + name
+}
@@ -0,0 +1,153 @@
+package keys
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	tt := []struct {
+		content string
+		key     string
+		err     error
+	}{
+		{content: "group-key\n", key: "group-key", err: nil},
+		{content: "group-key", key: "group-key", err: nil},
+		{content: "group-key\r\nsecond line\n", key: "group-key", err: nil},
+		{content: "with spaces \n", key: "with spaces ", err: nil},
+		{content: "", key: "", err: ErrEmptyKey},
+		{content: "\nkey\n", key: "", err: ErrEmptyKey},
+	}
+
+	dir, err := ioutil.TempDir("", "sherlock-keys")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, tc := range tt {
+		path := filepath.Join(dir, "key")
+		if err := ioutil.WriteFile(path, []byte(tc.content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile: %v", err)
+		}
+		key, ok, err := File(path).Key("default")
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("keys.File (%d): want: %v, have: %v", i, tc.err, err)
+		}
+		if key != tc.key || ok != (tc.err == nil) {
+			t.Fatalf("keys.File (%d): want: %q, have: %q (ok=%v)", i, tc.key, key, ok)
+		}
+	}
+
+	if _, _, err := File(filepath.Join(dir, "missing")).Key("default"); !os.IsNotExist(err) {
+		t.Fatalf("keys.File: want: not exist error, have: %v", err)
+	}
+}
+
+func TestReaderReadsOnce(t *testing.T) {
+	var opened int
+	r := &reader{
+		name: "test",
+		open: func() (io.ReadCloser, error) {
+			opened++
+			return ioutil.NopCloser(strings.NewReader("group-key\n")), nil
+		},
+	}
+	for _, gid := range []string{"default", "other"} {
+		if key, ok, err := r.Key(gid); err != nil || !ok || key != "group-key" {
+			t.Fatalf("reader.Key: want: group-key, have: %q (ok=%v, err=%v)", key, ok, err)
+		}
+	}
+	if opened != 1 {
+		t.Fatalf("reader.Key: want: source read once, have: %d", opened)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	src := strings.NewReader("group-key\ny\n")
+	line, err := readLine(src)
+	if err != nil || line != "group-key" {
+		t.Fatalf("keys.readLine: want: group-key, have: %q (%v)", line, err)
+	}
+	// input after the key must be left for following prompts
+	rest, _ := ioutil.ReadAll(src)
+	if string(rest) != "y\n" {
+		t.Fatalf("keys.readLine: want: %q left, have: %q", "y\n", rest)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	environ := map[string]string{
+		"SHERLOCK_KEY_DEFAULT":  "default-key",
+		"SHERLOCK_KEY_MY_GROUP": "my-group-key",
+		"SHERLOCK_KEY_EMPTY":    "",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := environ[name]
+		return v, ok
+	}
+
+	tt := []struct {
+		gid string
+		key string
+		ok  bool
+	}{
+		{gid: "default", key: "default-key", ok: true},
+		{gid: "my-group", key: "my-group-key", ok: true},
+		{gid: "My.Group", key: "my-group-key", ok: true},
+		{gid: "empty", key: "", ok: false},
+		{gid: "unknown", key: "", ok: false},
+	}
+	for _, tc := range tt {
+		key, ok, err := Env(lookup).Key(tc.gid)
+		if err != nil {
+			t.Fatalf("keys.Env: want: nil, have: %v", err)
+		}
+		if key != tc.key || ok != tc.ok {
+			t.Fatalf("keys.Env (%s): want: %q (ok=%v), have: %q (ok=%v)", tc.gid, tc.key, tc.ok, key, ok)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		if name == "SHERLOCK_KEY_PROD" {
+			return "prod-key", true
+		}
+		return "", false
+	}
+	failing := &reader{
+		name: "failing",
+		open: func() (io.ReadCloser, error) {
+			return nil, os.ErrPermission
+		},
+	}
+
+	tt := []struct {
+		chain Chain
+		gid   string
+		key   string
+		ok    bool
+		err   error
+	}{
+		{chain: Chain{}, gid: "prod", key: "", ok: false, err: nil},
+		{chain: Chain{Env(lookup)}, gid: "prod", key: "prod-key", ok: true, err: nil},
+		{chain: Chain{Env(lookup)}, gid: "dev", key: "", ok: false, err: nil},
+		{chain: Chain{Env(lookup), failing}, gid: "prod", key: "prod-key", ok: true, err: nil},
+		{chain: Chain{Env(lookup), failing}, gid: "dev", key: "", ok: false, err: os.ErrPermission},
+	}
+	for i, tc := range tt {
+		key, ok, err := tc.chain.Key(tc.gid)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("keys.Chain (%d): want: %v, have: %v", i, tc.err, err)
+		}
+		if key != tc.key || ok != tc.ok {
+			t.Fatalf("keys.Chain (%d): want: %q (ok=%v), have: %q (ok=%v)", i, tc.key, tc.ok, key, ok)
+		}
+	}
+}
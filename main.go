@@ -1,6 +1,9 @@
 package main
 
 import (
+	"errors"
+	"os"
+
 	"github.com/KonstantinGasser/sherlock/cmd"
 	"github.com/KonstantinGasser/sherlock/fs"
 	"github.com/KonstantinGasser/sherlock/internal"
@@ -9,11 +12,16 @@ import (
 )
 
 func main() {
-	fileSystem := fs.New(afero.NewOsFs())
-	sherlock := internal.NewSherlock(fileSystem)
+	newFileSystem := func(root string) internal.FileSystem {
+		return fs.New(afero.NewOsFs(), root)
+	}
 
-	if err := cmd.RootCmd(sherlock).Execute(); err != nil {
+	if err := cmd.RootCmd(newFileSystem).Execute(); err != nil {
+		var status cmd.ExitStatus
+		if errors.As(err, &status) {
+			os.Exit(int(status))
+		}
 		terminal.Error("%s", err)
-
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,213 @@
+package security
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/m1/go-generate-password/generator"
+)
+
+const (
+	// maxPasswordLength caps the length of generated passwords
+	maxPasswordLength = 1024
+)
+
+var (
+	ErrInvalidPolicy = fmt.Errorf("invalid password policy")
+)
+
+// Policy describes the passwords created by Generate
+type Policy struct {
+	Length int
+	// character classes the password is built from
+	Lower   bool
+	Upper   bool
+	Digits  bool
+	Symbols bool
+	// SymbolSet replaces the default symbols if set
+	SymbolSet string
+	// Exclude lists characters which are never used
+	Exclude string
+	// AllowSimilar allows characters which are easily
+	// confused like i, l, o, I, L, O, 0 or 1
+	AllowSimilar bool
+	// minimum number of characters per class
+	MinLower   int
+	MinUpper   int
+	MinDigits  int
+	MinSymbols int
+}
+
+// DefaultPolicy matches the passwords sherlock always generated: symbols,
+// digits, upper and lower case letters without similar or ambiguous characters
+var DefaultPolicy = Policy{
+	Length:  20,
+	Lower:   true,
+	Upper:   true,
+	Digits:  true,
+	Symbols: true,
+}
+
+// charClass is a character class of a policy
+// with its minimum number of characters
+type charClass struct {
+	name    string
+	enabled bool
+	set     string
+	min     int
+}
+
+func (p Policy) classes() []charClass {
+	symbols := removeChars(generator.DefaultSymbolSet, generator.DefaultSymbolAmbiguousSet)
+	if p.SymbolSet != "" {
+		symbols = p.SymbolSet
+	}
+	lower, upper, digits := generator.DefaultLetterSet, strings.ToUpper(generator.DefaultLetterSet), generator.DefaultNumberSet
+	if !p.AllowSimilar {
+		lower = removeChars(lower, generator.DefaultLetterAmbiguousSet)
+		upper = removeChars(upper, strings.ToUpper(generator.DefaultLetterAmbiguousSet))
+		digits = removeChars(digits, generator.DefaultNumberAmbiguousSet)
+	}
+	return []charClass{
+		{name: "lower case letters", enabled: p.Lower, set: removeChars(lower, p.Exclude), min: p.MinLower},
+		{name: "upper case letters", enabled: p.Upper, set: removeChars(upper, p.Exclude), min: p.MinUpper},
+		{name: "digits", enabled: p.Digits, set: removeChars(digits, p.Exclude), min: p.MinDigits},
+		{name: "symbols", enabled: p.Symbols, set: uniqueChars(removeChars(symbols, p.Exclude)), min: p.MinSymbols},
+	}
+}
+
+// Valid checks that passwords can be generated with the policy
+func (p Policy) Valid() error {
+	if p.Length <= 0 || p.Length > maxPasswordLength {
+		return fmt.Errorf("%w: length must be between 1 and %d", ErrInvalidPolicy, maxPasswordLength)
+	}
+	var min int
+	var enabled bool
+	for _, class := range p.classes() {
+		if class.min < 0 {
+			return fmt.Errorf("%w: negative minimum for %s", ErrInvalidPolicy, class.name)
+		}
+		if !class.enabled {
+			if class.min > 0 {
+				return fmt.Errorf("%w: minimum for %s which are disabled", ErrInvalidPolicy, class.name)
+			}
+			continue
+		}
+		if class.set == "" {
+			return fmt.Errorf("%w: all %s are excluded", ErrInvalidPolicy, class.name)
+		}
+		enabled = true
+		min += class.min
+	}
+	if !enabled {
+		return fmt.Errorf("%w: no character class enabled", ErrInvalidPolicy)
+	}
+	if min > p.Length {
+		return fmt.Errorf("%w: minimum counts (%d) exceed the length (%d)", ErrInvalidPolicy, min, p.Length)
+	}
+	return nil
+}
+
+// charset returns all characters a password of the policy can hold. Custom
+// symbols may overlap the other classes, every character is kept once so
+// none of them is picked more likely than the others
+func (p Policy) charset() string {
+	var set string
+	for _, class := range p.classes() {
+		if class.enabled {
+			set += class.set
+		}
+	}
+	return uniqueChars(set)
+}
+
+// Entropy estimates the entropy in bits of passwords generated with
+// the policy assuming every character is picked from all enabled classes
+func (p Policy) Entropy() float64 {
+	size := len(p.charset())
+	if size == 0 {
+		return 0
+	}
+	return float64(p.Length) * math.Log2(float64(size))
+}
+
+// Generate creates a random password following the policy. The minimum
+// characters per class are picked first, the rest is picked from all enabled
+// classes and the result is shuffled
+func Generate(p Policy) (string, error) {
+	if err := p.Valid(); err != nil {
+		return "", err
+	}
+	password := make([]byte, 0, p.Length)
+	for _, class := range p.classes() {
+		if !class.enabled {
+			continue
+		}
+		for i := 0; i < class.min; i++ {
+			c, err := randomChar(class.set)
+			if err != nil {
+				return "", err
+			}
+			password = append(password, c)
+		}
+	}
+	charset := p.charset()
+	for len(password) < p.Length {
+		c, err := randomChar(charset)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	// Fisher-Yates shuffle so the minimum characters
+	// do not always lead the password
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
+
+func randomChar(set string) (byte, error) {
+	i, err := randomInt(len(set))
+	if err != nil {
+		return 0, err
+	}
+	return set[i], nil
+}
+
+func randomInt(max int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64()), nil
+}
+
+func removeChars(set, chars string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(chars, r) {
+			return -1
+		}
+		return r
+	}, set)
+}
+
+// uniqueChars drops duplicate and non printable ASCII characters
+// of a user provided symbol set
+func uniqueChars(set string) string {
+	var out strings.Builder
+	for _, r := range set {
+		if r <= ' ' || r > '~' || strings.ContainsRune(out.String(), r) {
+			continue
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
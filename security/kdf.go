@@ -0,0 +1,157 @@
+package security
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// saltSize is the length of the random per-vault salt
+	saltSize = 16
+	// argon2ParamsSize is the length of the encoded parameters
+	// and salt (see argon2Params)
+	argon2ParamsSize = 4 + 4 + 1 + saltSize
+
+	// minKDFMemory and maxKDFMemory (in KiB) bound the memory parameter.
+	// The upper bound protects against vaults with a manipulated header
+	// forcing sherlock to allocate arbitrary amounts of memory
+	minKDFMemory = 8 * 1024
+	maxKDFMemory = 4 * 1024 * 1024
+	// maxKDFTime bounds the number of passes for the same reason
+	maxKDFTime = 64
+)
+
+// KDFParams are the cost parameters of the Argon2id key derivation.
+// They are stored in the header of every vault so that each vault can
+// be opened with the parameters it was written with
+type KDFParams struct {
+	// Time is the number of passes over the memory
+	Time uint32 `json:"time"`
+	// Memory is the amount of memory used in KiB
+	Memory uint32 `json:"memory"`
+	// Threads is the degree of parallelism
+	Threads uint8 `json:"threads"`
+}
+
+// DefaultKDFParams follow the recommendation of RFC 9106 for
+// memory-constrained environments
+var DefaultKDFParams = KDFParams{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+// valid verifies that the parameters are within the bounds
+// sherlock is willing to use
+func (p KDFParams) valid() error {
+	if p.Time < 1 || p.Time > maxKDFTime {
+		return ErrInvalidKDFParams
+	}
+	if p.Memory < minKDFMemory || p.Memory > maxKDFMemory {
+		return ErrInvalidKDFParams
+	}
+	if p.Threads < 1 {
+		return ErrInvalidKDFParams
+	}
+	return nil
+}
+
+// argon2Params encodes the parameters and the salt as stored in the header:
+//
+//	time(4) | memory(4) | threads(1) | salt(16)
+func argon2Params(p KDFParams, salt []byte) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, p.Time)
+	_ = binary.Write(&buf, binary.BigEndian, p.Memory)
+	buf.WriteByte(p.Threads)
+	buf.Write(salt)
+	return buf.Bytes()
+}
+
+// parseArgon2Params decodes the KDF parameters of a vault header
+func parseArgon2Params(b []byte) (KDFParams, []byte, error) {
+	var p KDFParams
+	if len(b) != argon2ParamsSize {
+		return p, nil, ErrCorruptedVault
+	}
+	p.Time = binary.BigEndian.Uint32(b[0:4])
+	p.Memory = binary.BigEndian.Uint32(b[4:8])
+	p.Threads = b[8]
+	if err := p.valid(); err != nil {
+		return p, nil, ErrCorruptedVault
+	}
+	return p, b[9:], nil
+}
+
+// newSalt returns a random salt for a new vault
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// argon2Key derives the key material from the group key
+func argon2Key(key string, salt []byte, p KDFParams) []byte {
+	return argon2.IDKey([]byte(key), salt, p.Time, p.Memory, p.Threads, encKeySize*2)
+}
+
+// BenchmarkKDF picks KDF parameters for the current machine such that
+// deriving a key takes about the target duration. The memory parameter
+// is kept at the default unless a single pass already exceeds the target,
+// in which case it is halved until it fits. It returns the parameters and
+// the measured duration of one key derivation
+func BenchmarkKDF(target time.Duration) (KDFParams, time.Duration) {
+	p := KDFParams{
+		Time:    1,
+		Memory:  DefaultKDFParams.Memory,
+		Threads: benchmarkThreads(),
+	}
+	salt := make([]byte, saltSize)
+
+	took := measureKDF(salt, p)
+	for took > target && p.Memory/2 >= minKDFMemory {
+		p.Memory /= 2
+		took = measureKDF(salt, p)
+	}
+	for took < target && p.Time < maxKDFTime {
+		// estimate the passes required based on the last measurement
+		// and never grow by more than a factor of two to not overshoot
+		next := p.Time * 2
+		if perPass := took / time.Duration(p.Time); perPass > 0 {
+			if estimate := uint32(target / perPass); estimate < next {
+				next = estimate
+			}
+		}
+		if next <= p.Time {
+			break
+		}
+		if next > maxKDFTime {
+			next = maxKDFTime
+		}
+		p.Time = next
+		took = measureKDF(salt, p)
+	}
+	return p, took
+}
+
+func benchmarkThreads() uint8 {
+	threads := runtime.NumCPU()
+	if threads > int(DefaultKDFParams.Threads) {
+		threads = int(DefaultKDFParams.Threads)
+	}
+	return uint8(threads)
+}
+
+func measureKDF(salt []byte, p KDFParams) time.Duration {
+	start := time.Now()
+	_ = argon2Key("sherlock-kdf-benchmark", salt, p)
+	return time.Since(start)
+}
@@ -0,0 +1,68 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// IsLegacy reports whether b is a vault written before vaults carried a
+// header. Such vaults are encrypted with AES-CFB and a key taken from an
+// unsalted SHA-256 of the group key and can only be read.
+//
+// Legacy vaults are random bytes (IV and ciphertext) so they can only be told
+// apart from damaged current vaults by what they are not: a vault whose magic
+// is off by a single byte or whose header follows a damaged magic is a current
+// vault and reported as corrupted by Decrypt instead
+func IsLegacy(b []byte) bool {
+	if len(b) <= aes.BlockSize || len(b) <= len(vaultMagic)+3 {
+		return false
+	}
+	matching := 0
+	for i, c := range vaultMagic {
+		if b[i] == c {
+			matching++
+		}
+	}
+	if matching >= len(vaultMagic)-1 {
+		return false
+	}
+	// version, cipher and kdf of the header right after the magic
+	rest := b[len(vaultMagic):]
+	return !(rest[0] == formatVersion && rest[1] == cipherAESGCM && rest[2] == kdfArgon2id)
+}
+
+// legacyHash derives the key of a legacy vault. Only the first 16 bytes
+// of the result are used as AES key
+func legacyHash(key string) []byte {
+	b := sha256.Sum256([]byte(key))
+	hexB := hex.EncodeToString(b[:])
+	return []byte(hexB)
+}
+
+// decryptLegacy decrypts a legacy vault. Since the legacy format is not
+// authenticated a wrong key and a damaged vault cannot be told apart; both
+// result in ErrWrongKey
+func decryptLegacy(b []byte, key string, v interface{}) error {
+	if len(b) < aes.BlockSize {
+		return ErrCorruptedVault
+	}
+	aesKey := legacyHash(key)
+
+	block, err := aes.NewCipher(aesKey[:16])
+	if err != nil {
+		return err
+	}
+
+	decrypted := make([]byte, len(b)-aes.BlockSize)
+	iv := b[:aes.BlockSize]
+	stream := cipher.NewCFBDecrypter(block, iv)
+	stream.XORKeyStream(decrypted, b[aes.BlockSize:])
+
+	if err := json.Unmarshal(decrypted, &v); err != nil {
+		return ErrWrongKey
+	}
+	return nil
+}
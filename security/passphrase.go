@@ -0,0 +1,96 @@
+package security
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+const (
+	// maxPassphraseWords caps the number of words of a passphrase
+	maxPassphraseWords = 64
+)
+
+var (
+	wordsOnce sync.Once
+	words     []string
+)
+
+// wordlist returns the words of the EFF large wordlist
+func wordlist() []string {
+	wordsOnce.Do(func() {
+		words = strings.Split(effWordlist, "\n")
+	})
+	return words
+}
+
+// PassphrasePolicy describes the passphrases created by GeneratePassphrase
+type PassphrasePolicy struct {
+	Words     int
+	Separator string
+	// Capitalize upper cases the first letter of every word
+	Capitalize bool
+	// Digit appends a random digit to a random word
+	Digit bool
+}
+
+// DefaultPassphrasePolicy creates passphrases of six words (~77 bits)
+var DefaultPassphrasePolicy = PassphrasePolicy{
+	Words:     6,
+	Separator: "-",
+}
+
+// Valid checks that passphrases can be generated with the policy
+func (p PassphrasePolicy) Valid() error {
+	if p.Words <= 0 || p.Words > maxPassphraseWords {
+		return fmt.Errorf("%w: number of words must be between 1 and %d", ErrInvalidPolicy, maxPassphraseWords)
+	}
+	return nil
+}
+
+// Entropy returns the entropy in bits of passphrases generated with the
+// policy. Capitalization adds no entropy since every word is capitalized
+func (p PassphrasePolicy) Entropy() float64 {
+	if p.Words <= 0 {
+		return 0
+	}
+	bits := float64(p.Words) * math.Log2(float64(len(wordlist())))
+	if p.Digit {
+		// the digit and the word it is appended to
+		bits += math.Log2(10) + math.Log2(float64(p.Words))
+	}
+	return bits
+}
+
+// GeneratePassphrase picks random words of the EFF large wordlist
+// following the policy
+func GeneratePassphrase(p PassphrasePolicy) (string, error) {
+	if err := p.Valid(); err != nil {
+		return "", err
+	}
+	list := wordlist()
+	picked := make([]string, p.Words)
+	for i := range picked {
+		n, err := randomInt(len(list))
+		if err != nil {
+			return "", err
+		}
+		picked[i] = list[n]
+		if p.Capitalize {
+			picked[i] = strings.ToUpper(picked[i][:1]) + picked[i][1:]
+		}
+	}
+	if p.Digit {
+		i, err := randomInt(len(picked))
+		if err != nil {
+			return "", err
+		}
+		digit, err := randomInt(10)
+		if err != nil {
+			return "", err
+		}
+		picked[i] += fmt.Sprintf("%d", digit)
+	}
+	return strings.Join(picked, p.Separator), nil
+}
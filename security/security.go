@@ -4,114 +4,138 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
-	"crypto/sha256"
-	"encoding/hex"
+	"crypto/subtle"
 	"encoding/json"
+	"fmt"
 	"io"
-
-	"github.com/m1/go-generate-password/generator"
-	passwordvalidator "github.com/wagslane/go-password-validator"
 )
 
-const (
-	// minStrength is the lower limit a password has to be secure
-	minStrength = 60
+var (
+	ErrInvalidKDFParams = fmt.Errorf("invalid key derivation parameters")
+	ErrWrongKey         = fmt.Errorf("wrong key")
+	ErrCorruptedVault   = fmt.Errorf("vault corrupted or tampered")
+	ErrUnsupportedVault = fmt.Errorf("vault format not supported by this version of sherlock")
+	ErrWeakPassword     = fmt.Errorf("password is too weak")
+	ErrNoVaultKey       = fmt.Errorf("vault format does not support derived keys (change the group once or use sherlock migrate)")
 )
 
-func hash(key string) []byte {
-	b := sha256.Sum256([]byte(key))
-	hexB := hex.EncodeToString(b[:])
-	return []byte(hexB)
-}
-
 // InitWithDefault encrypts and empty map[string]interface with a
 // provided key
-func InitWithDefault(key string, defaultVault interface{}) ([]byte, error) {
+func InitWithDefault(key string, defaultVault interface{}, params KDFParams) ([]byte, error) {
 	byteVault, err := json.Marshal(defaultVault)
 	if err != nil {
 		return nil, err
 	}
-	aesKey := hash(key)
-	block, err := aes.NewCipher(aesKey[:16])
-	if err != nil {
-		return nil, err
-	}
-	encypted := make([]byte, aes.BlockSize+len(byteVault))
-	iv := encypted[:aes.BlockSize]
+	return Encrypt(byteVault, key, params)
+}
 
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+// Encrypt encrypts the data using the key which is derived with the
+// given KDF parameters and a fresh salt. A vault key (see DeriveVaultKey)
+// keeps the salt and parameters it was derived with instead. The returned
+// bytes are prefixed with the vault header
+func Encrypt(b []byte, key string, params KDFParams) ([]byte, error) {
+	h := header{
+		Version: formatVersion,
+		Cipher:  cipherAESGCM,
+		KDF:     kdfArgon2id,
+	}
+	if vk, ok := parseVaultKey(key); ok {
+		h.KDFParams = vk.kdfParams
+	} else {
+		if err := params.valid(); err != nil {
+			return nil, err
+		}
+		salt, err := newSalt()
+		if err != nil {
+			return nil, err
+		}
+		h.KDFParams = argon2Params(params, salt)
+	}
+	aesKey, keyCheck, err := deriveKeys(&h, key)
+	if err != nil {
 		return nil, err
 	}
+	h.KeyCheck = keyCheck
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(encypted[aes.BlockSize:], byteVault)
-
-	return encypted, err
-}
-
-// Encrypt encrypts the data using the key
-func Encrypt(b []byte, key string) ([]byte, error) {
-	aeskey := hash(key)
-
-	block, err := aes.NewCipher(aeskey[:16])
+	gcm, err := newGCM(aesKey)
 	if err != nil {
 		return nil, err
 	}
-	encrypted := make([]byte, aes.BlockSize+len(b))
-
-	iv := encrypted[:aes.BlockSize]
-
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		panic(err)
+	h.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, h.Nonce); err != nil {
+		return nil, err
 	}
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-
-	stream.XORKeyStream(encrypted[aes.BlockSize:], b)
-
-	return encrypted, nil
+	raw := h.marshal()
+	return gcm.Seal(raw, h.Nonce, b, raw), nil
 }
 
-// Decrypt encrypts the data using the key
+// Decrypt decrypts the data using the key (the group key or a vault
+// key, see DeriveVaultKey) and unmarshals the result into v.
+//
+// It returns ErrWrongKey if the key does not match the vault and
+// ErrCorruptedVault if the vault has been damaged or tampered with.
+// Legacy vaults (see IsLegacy) are decrypted with the legacy scheme
 func Decrypt(b []byte, key string, v interface{}) error {
-	aesKey := hash(key)
-
-	block, err := aes.NewCipher(aesKey[:16])
+	if IsLegacy(b) {
+		if _, ok := parseVaultKey(key); ok {
+			return ErrWrongKey
+		}
+		return decryptLegacy(b, key, v)
+	}
+	h, raw, ciphertext, err := parseHeader(b)
 	if err != nil {
 		return err
 	}
+	if h.Cipher != cipherAESGCM {
+		return ErrUnsupportedVault
+	}
+	aesKey, keyCheck, err := deriveKeys(h, key)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(keyCheck, h.KeyCheck) != 1 {
+		return ErrWrongKey
+	}
 
-	decrypted := b[aes.BlockSize:]
-
-	iv := b[:aes.BlockSize]
-	stream := cipher.NewCFBDecrypter(block, iv)
-	stream.XORKeyStream(decrypted, decrypted)
-
-	if err := json.Unmarshal(decrypted, &v); err != nil {
+	gcm, err := newGCM(aesKey)
+	if err != nil {
 		return err
 	}
+	if len(h.Nonce) != gcm.NonceSize() {
+		return ErrCorruptedVault
+	}
+	decrypted, err := gcm.Open(nil, h.Nonce, ciphertext, raw)
+	if err != nil {
+		return ErrCorruptedVault
+	}
+	if err := json.Unmarshal(decrypted, &v); err != nil {
+		return ErrCorruptedVault
+	}
 	return nil
 }
 
-// PasswordStrength evaluates how strong the password is based on
-// the variety and diversity of the chosen characters
-func PasswordStrength(password string) error {
-	return passwordvalidator.Validate(password, minStrength)
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
 
-func GenPassword(len int) (string, error) {
-	gen, err := generator.New(&generator.Config{
-		Length:                     len,
-		IncludeSymbols:             true,
-		IncludeNumbers:             true,
-		IncludeLowercaseLetters:    true,
-		IncludeUppercaseLetters:    true,
-		ExcludeSimilarCharacters:   true,
-		ExcludeAmbiguousCharacters: true,
-	})
-	if err != nil {
-		return "", err
+// PasswordStrength evaluates whether the password has at least
+// minEntropy bits of entropy (see MeasureStrength)
+func PasswordStrength(password string, minEntropy float64) error {
+	if strength := MeasureStrength(password); !strength.Secure(minEntropy) {
+		return fmt.Errorf("%w: ~%.0f bits of entropy, at least %.0f required", ErrWeakPassword, strength.Entropy, minEntropy)
 	}
-	password, err := gen.Generate()
-	return *password, err
+	return nil
+}
+
+// GenPassword generates a password of the given length
+// following the DefaultPolicy
+func GenPassword(length int) (string, error) {
+	policy := DefaultPolicy
+	policy.Length = length
+	return Generate(policy)
 }
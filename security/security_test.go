@@ -0,0 +1,522 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type testVault struct {
+	Name string `json:"name"`
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	encrypted, err := InitWithDefault("group-key", testVault{Name: "sherlock"}, DefaultKDFParams)
+	if err != nil {
+		t.Fatalf("security.InitWithDefault: want: nil, have: %v", err)
+	}
+
+	var v testVault
+	if err := Decrypt(encrypted, "group-key", &v); err != nil {
+		t.Fatalf("security.Decrypt: want: nil, have: %v", err)
+	}
+	if v.Name != "sherlock" {
+		t.Fatalf("security.Decrypt: want: %q, have: %q", "sherlock", v.Name)
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	encrypted, err := InitWithDefault("group-key", testVault{Name: "sherlock"}, DefaultKDFParams)
+	if err != nil {
+		t.Fatalf("security.InitWithDefault: want: nil, have: %v", err)
+	}
+	h, raw, _, err := parseHeader(encrypted)
+	if err != nil {
+		t.Fatalf("security.parseHeader: want: nil, have: %v", err)
+	}
+
+	tt := []struct {
+		name   string
+		key    string
+		vault  func() []byte
+		expect error
+	}{
+		{
+			name:   "wrong key",
+			key:    "not-the-group-key",
+			vault:  func() []byte { return encrypted },
+			expect: ErrWrongKey,
+		},
+		{
+			name: "flipped bit in ciphertext",
+			key:  "group-key",
+			vault: func() []byte {
+				return flip(encrypted, len(encrypted)-1)
+			},
+			expect: ErrCorruptedVault,
+		},
+		{
+			name: "flipped bit in nonce",
+			key:  "group-key",
+			vault: func() []byte {
+				return flip(encrypted, len(raw)-len(h.Nonce))
+			},
+			expect: ErrCorruptedVault,
+		},
+		{
+			name: "truncated vault",
+			key:  "group-key",
+			vault: func() []byte {
+				return encrypted[:len(raw)-1]
+			},
+			expect: ErrCorruptedVault,
+		},
+		{
+			name: "missing magic bytes",
+			key:  "group-key",
+			vault: func() []byte {
+				return flip(encrypted, 0)
+			},
+			expect: ErrCorruptedVault,
+		},
+		{
+			name: "overwritten magic bytes",
+			key:  "group-key",
+			vault: func() []byte {
+				c := append([]byte(nil), encrypted...)
+				copy(c, "\x00\x00\x00\x00")
+				return c
+			},
+			expect: ErrCorruptedVault,
+		},
+		{
+			name: "manipulated kdf memory parameter",
+			key:  "group-key",
+			vault: func() []byte {
+				// memory is stored right after time in the kdf parameters
+				// which start after magic, version, cipher, kdf and their length.
+				// Setting its most significant byte exceeds maxKDFMemory
+				offset := len(vaultMagic) + 3 + 2 + 4
+				c := flip(encrypted, offset)
+				c[offset] = 0xff
+				return c
+			},
+			expect: ErrCorruptedVault,
+		},
+		{
+			name: "unknown format version",
+			key:  "group-key",
+			vault: func() []byte {
+				return flip(encrypted, len(vaultMagic))
+			},
+			expect: ErrUnsupportedVault,
+		},
+	}
+
+	for _, tc := range tt {
+		var v testVault
+		if err := Decrypt(tc.vault(), tc.key, &v); err != tc.expect {
+			t.Fatalf("security.Decrypt: %s: want: %v, have: %v", tc.name, tc.expect, err)
+		}
+	}
+}
+
+// flip returns a copy of b with the lowest bit of b[i] flipped
+func flip(b []byte, i int) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	c[i] ^= 0x01
+	return c
+}
+
+func TestEncryptUsesFreshSalt(t *testing.T) {
+	first, err := Encrypt([]byte(`{}`), "group-key", DefaultKDFParams)
+	if err != nil {
+		t.Fatalf("security.Encrypt: want: nil, have: %v", err)
+	}
+	second, err := Encrypt([]byte(`{}`), "group-key", DefaultKDFParams)
+	if err != nil {
+		t.Fatalf("security.Encrypt: want: nil, have: %v", err)
+	}
+	h1, _, _, _ := parseHeader(first)
+	h2, _, _, _ := parseHeader(second)
+	if string(h1.KDFParams) == string(h2.KDFParams) {
+		t.Fatalf("security.Encrypt: want: different salts, have: %x", h1.KDFParams)
+	}
+}
+
+func TestKDFParamsValid(t *testing.T) {
+	tt := []struct {
+		name   string
+		params KDFParams
+		expect error
+	}{
+		{
+			name:   "default parameters",
+			params: DefaultKDFParams,
+			expect: nil,
+		},
+		{
+			name:   "zero passes",
+			params: KDFParams{Time: 0, Memory: 64 * 1024, Threads: 1},
+			expect: ErrInvalidKDFParams,
+		},
+		{
+			name:   "too little memory",
+			params: KDFParams{Time: 1, Memory: 1024, Threads: 1},
+			expect: ErrInvalidKDFParams,
+		},
+		{
+			name:   "too much memory",
+			params: KDFParams{Time: 1, Memory: maxKDFMemory + 1, Threads: 1},
+			expect: ErrInvalidKDFParams,
+		},
+	}
+	for _, tc := range tt {
+		if err := tc.params.valid(); err != tc.expect {
+			t.Fatalf("KDFParams.valid: %s: want: %v, have: %v", tc.name, tc.expect, err)
+		}
+	}
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	legacy := encryptLegacy(t, "group-key", testVault{Name: "sherlock"})
+	if !IsLegacy(legacy) {
+		t.Fatalf("security.IsLegacy: want: true, have: false")
+	}
+
+	var v testVault
+	if err := Decrypt(legacy, "group-key", &v); err != nil {
+		t.Fatalf("security.Decrypt: want: nil, have: %v", err)
+	}
+	if v.Name != "sherlock" {
+		t.Fatalf("security.Decrypt: want: %q, have: %q", "sherlock", v.Name)
+	}
+	if err := Decrypt(legacy, "wrong-key", &v); err != ErrWrongKey {
+		t.Fatalf("security.Decrypt: want: %v, have: %v", ErrWrongKey, err)
+	}
+
+	current, err := InitWithDefault("group-key", v, DefaultKDFParams)
+	if err != nil {
+		t.Fatalf("security.InitWithDefault: want: nil, have: %v", err)
+	}
+	if IsLegacy(current) {
+		t.Fatalf("security.IsLegacy: want: false, have: true")
+	}
+}
+
+// encryptLegacy encrypts v the way vaults were written before they
+// carried a header
+func encryptLegacy(t *testing.T, key string, v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	aesKey := legacyHash(key)
+	block, err := aes.NewCipher(aesKey[:16])
+	if err != nil {
+		t.Fatalf("aes.NewCipher: %v", err)
+	}
+	encrypted := make([]byte, aes.BlockSize+len(b))
+	iv := encrypted[:aes.BlockSize]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(encrypted[aes.BlockSize:], b)
+	return encrypted
+}
+
+func TestPolicyValid(t *testing.T) {
+	tt := []struct {
+		name   string
+		policy Policy
+		ok     bool
+	}{
+		{
+			name:   "default policy",
+			policy: DefaultPolicy,
+			ok:     true,
+		},
+		{
+			name:   "zero length",
+			policy: Policy{Length: 0, Lower: true},
+			ok:     false,
+		},
+		{
+			name:   "no character class",
+			policy: Policy{Length: 20},
+			ok:     false,
+		},
+		{
+			name:   "minimum of disabled class",
+			policy: Policy{Length: 20, Lower: true, MinDigits: 2},
+			ok:     false,
+		},
+		{
+			name:   "minimums exceed length",
+			policy: Policy{Length: 4, Lower: true, Digits: true, MinLower: 3, MinDigits: 2},
+			ok:     false,
+		},
+		{
+			name:   "all symbols excluded",
+			policy: Policy{Length: 20, Symbols: true, SymbolSet: "!?", Exclude: "!?"},
+			ok:     false,
+		},
+	}
+	for _, tc := range tt {
+		err := tc.policy.Valid()
+		if (err == nil) != tc.ok {
+			t.Fatalf("Policy.Valid: %s: want: ok==%v, have: %v", tc.name, tc.ok, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidPolicy) {
+			t.Fatalf("Policy.Valid: %s: want: %v, have: %v", tc.name, ErrInvalidPolicy, err)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tt := []struct {
+		name   string
+		policy Policy
+		// allowed are all characters the password may contain
+		allowed string
+	}{
+		{
+			name:    "digits only",
+			policy:  Policy{Length: 12, Digits: true, AllowSimilar: true},
+			allowed: "0123456789",
+		},
+		{
+			name:    "custom symbols and minimums",
+			policy:  Policy{Length: 8, Lower: true, Symbols: true, SymbolSet: "#!", MinSymbols: 6},
+			allowed: "abcdefghkmnpqrstuvwxyz#!",
+		},
+		{
+			name:    "excluded characters",
+			policy:  Policy{Length: 30, Digits: true, Exclude: "2345678"},
+			allowed: "9",
+		},
+	}
+	for _, tc := range tt {
+		for i := 0; i < 20; i++ {
+			password, err := Generate(tc.policy)
+			if err != nil {
+				t.Fatalf("security.Generate: %s: want: nil, have: %v", tc.name, err)
+			}
+			if len(password) != tc.policy.Length {
+				t.Fatalf("security.Generate: %s: want: length %d, have: %d", tc.name, tc.policy.Length, len(password))
+			}
+			var symbols int
+			for _, c := range password {
+				if !strings.ContainsRune(tc.allowed, c) {
+					t.Fatalf("security.Generate: %s: unexpected character %q in %q", tc.name, c, password)
+				}
+				if strings.ContainsRune(tc.policy.SymbolSet, c) {
+					symbols++
+				}
+			}
+			if symbols < tc.policy.MinSymbols {
+				t.Fatalf("security.Generate: %s: want: at least %d symbols, have: %d", tc.name, tc.policy.MinSymbols, symbols)
+			}
+		}
+	}
+}
+
+func TestPolicyEntropy(t *testing.T) {
+	// 8 digits (0 and 1 are similar) => 10 * log2(8) = 30 bits
+	policy := Policy{Length: 10, Digits: true}
+	if bits := policy.Entropy(); bits != 30 {
+		t.Fatalf("Policy.Entropy: want: 30, have: %v", bits)
+	}
+	// symbols overlapping the digits do not add to the charset
+	policy = Policy{Length: 10, Digits: true, Symbols: true, SymbolSet: "23456789"}
+	if bits := policy.Entropy(); bits != 30 {
+		t.Fatalf("Policy.Entropy: overlapping symbols: want: 30, have: %v", bits)
+	}
+	if charset := policy.charset(); charset != "23456789" {
+		t.Fatalf("Policy.charset: want: %q, have: %q", "23456789", charset)
+	}
+}
+
+func TestWordlist(t *testing.T) {
+	list := wordlist()
+	// the EFF large wordlist covers all rolls of five dice
+	if len(list) != 7776 {
+		t.Fatalf("security.wordlist: want: 7776 words, have: %d", len(list))
+	}
+	seen := make(map[string]bool, len(list))
+	for _, word := range list {
+		if word == "" || seen[word] {
+			t.Fatalf("security.wordlist: empty or duplicate word %q", word)
+		}
+		seen[word] = true
+	}
+}
+
+func TestGeneratePassphrase(t *testing.T) {
+	tt := []struct {
+		name   string
+		policy PassphrasePolicy
+		ok     bool
+	}{
+		{name: "default policy", policy: DefaultPassphrasePolicy, ok: true},
+		{name: "capitalized with digit", policy: PassphrasePolicy{Words: 4, Separator: " ", Capitalize: true, Digit: true}, ok: true},
+		{name: "no words", policy: PassphrasePolicy{Words: 0, Separator: "-"}, ok: false},
+	}
+	for _, tc := range tt {
+		passphrase, err := GeneratePassphrase(tc.policy)
+		if (err == nil) != tc.ok {
+			t.Fatalf("security.GeneratePassphrase: %s: want: ok==%v, have: %v", tc.name, tc.ok, err)
+		}
+		if !tc.ok {
+			continue
+		}
+		parts := strings.Split(passphrase, tc.policy.Separator)
+		if tc.policy.Separator == "-" {
+			// some words of the list hold a "-" themselves
+			parts = strings.SplitN(passphrase, tc.policy.Separator, tc.policy.Words)
+		}
+		if len(parts) != tc.policy.Words {
+			t.Fatalf("security.GeneratePassphrase: %s: want: %d words, have: %q", tc.name, tc.policy.Words, passphrase)
+		}
+		var digits int
+		for _, part := range parts {
+			if tc.policy.Capitalize && strings.ToUpper(part[:1]) != part[:1] {
+				t.Fatalf("security.GeneratePassphrase: %s: word %q not capitalized", tc.name, part)
+			}
+			if strings.ContainsAny(part, "0123456789") {
+				digits++
+			}
+		}
+		if tc.policy.Digit && digits != 1 {
+			t.Fatalf("security.GeneratePassphrase: %s: want: one word with a digit, have: %q", tc.name, passphrase)
+		}
+	}
+}
+
+func TestPassphraseEntropy(t *testing.T) {
+	// log2(7776) = 12.925 bits per word
+	if bits := DefaultPassphrasePolicy.Entropy(); bits < 77.5 || bits > 77.6 {
+		t.Fatalf("PassphrasePolicy.Entropy: want: ~77.5 bits, have: %v", bits)
+	}
+}
+
+func TestMeasureStrength(t *testing.T) {
+	tt := []struct {
+		password   string
+		secure     bool
+		weaknesses []Weakness
+	}{
+		{password: "x7#Kq!pZ2m@Lw9$e", secure: true},
+		{password: "Password1!", secure: false, weaknesses: []Weakness{WeaknessTooShort, WeaknessDictionary}},
+		{password: "qwerty123456", secure: false, weaknesses: []Weakness{WeaknessKeyboardWalk}},
+		{password: "aaaaaaaaaaaaaaaaaaaa", secure: false, weaknesses: []Weakness{WeaknessRepeated}},
+		{password: "abab-7Gk!abab-7Gk!", secure: false, weaknesses: []Weakness{WeaknessRepeated}},
+		{password: "helloworld", secure: false, weaknesses: []Weakness{WeaknessTooShort, WeaknessMissingClasses}},
+		// a diceware passphrase is measured by its words, not its characters
+		{password: "unsaid-kitten-polar-scorch-mystify-bagpipe", secure: true, weaknesses: []Weakness{WeaknessDictionary}},
+	}
+	for _, tc := range tt {
+		s := MeasureStrength(tc.password)
+		if s.Secure(DefaultMinEntropy) != tc.secure {
+			t.Fatalf("security.MeasureStrength: %q: want: secure==%v, have: %.1f bits", tc.password, tc.secure, s.Entropy)
+		}
+		if len(s.Weaknesses) != len(s.Suggestions) {
+			t.Fatalf("security.MeasureStrength: %q: want: a suggestion per weakness, have: %v %v", tc.password, s.Weaknesses, s.Suggestions)
+		}
+		for _, want := range tc.weaknesses {
+			var found bool
+			for _, w := range s.Weaknesses {
+				found = found || w == want
+			}
+			if !found {
+				t.Fatalf("security.MeasureStrength: %q: want: %q, have: %v", tc.password, want, s.Weaknesses)
+			}
+		}
+	}
+}
+
+func TestHasRepetition(t *testing.T) {
+	tt := []struct {
+		password string
+		expect   bool
+	}{
+		{password: "aaa", expect: true},
+		{password: "aab", expect: false},
+		{password: "abab", expect: true},
+		{password: "abcxabc", expect: false},
+		{password: "x7#kabc!abc!q", expect: true},
+		{password: "x7#kq!pz2m@lw9$e", expect: false},
+	}
+	for _, tc := range tt {
+		if have := hasRepetition(tc.password); have != tc.expect {
+			t.Fatalf("security.hasRepetition: %q: want: %v, have: %v", tc.password, tc.expect, have)
+		}
+	}
+}
+
+func TestPasswordStrength(t *testing.T) {
+	if err := PasswordStrength("helloworld", DefaultMinEntropy); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("security.PasswordStrength: want: %v, have: %v", ErrWeakPassword, err)
+	}
+	if err := PasswordStrength("fsdf$35dfg0-43563sdf34", DefaultMinEntropy); err != nil {
+		t.Fatalf("security.PasswordStrength: want: nil, have: %v", err)
+	}
+	if err := PasswordStrength("fsdf$35dfg0-43563sdf34", 200); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("security.PasswordStrength: min 200 bits: want: %v, have: %v", ErrWeakPassword, err)
+	}
+}
+
+func TestVaultKey(t *testing.T) {
+	encrypted, err := InitWithDefault("group-key", testVault{Name: "sherlock"}, DefaultKDFParams)
+	if err != nil {
+		t.Fatalf("security.InitWithDefault: want: nil, have: %v", err)
+	}
+	if _, err := DeriveVaultKey(encrypted, "wrong-key"); err != ErrWrongKey {
+		t.Fatalf("security.DeriveVaultKey: wrong key: want: %v, have: %v", ErrWrongKey, err)
+	}
+	key, err := DeriveVaultKey(encrypted, "group-key")
+	if err != nil {
+		t.Fatalf("security.DeriveVaultKey: want: nil, have: %v", err)
+	}
+	if strings.Contains(key, "group-key") {
+		t.Fatalf("security.DeriveVaultKey: want: no group key, have: %q", key)
+	}
+
+	var v testVault
+	if err := Decrypt(encrypted, key, &v); err != nil || v.Name != "sherlock" {
+		t.Fatalf("security.Decrypt: vault key: want: %q, have: %q (err: %v)", "sherlock", v.Name, err)
+	}
+	// a vault written with the vault key keeps its salt and
+	// opens with the vault key as well as with the group key
+	rewritten, err := Encrypt([]byte(`{"name":"watson"}`), key, DefaultKDFParams)
+	if err != nil {
+		t.Fatalf("security.Encrypt: vault key: want: nil, have: %v", err)
+	}
+	for _, k := range []string{key, "group-key"} {
+		if err := Decrypt(rewritten, k, &v); err != nil || v.Name != "watson" {
+			t.Fatalf("security.Decrypt: rewritten vault: want: %q, have: %q (err: %v)", "watson", v.Name, err)
+		}
+	}
+	// a fresh salt invalidates the vault key
+	fresh, err := Encrypt([]byte(`{}`), "group-key", DefaultKDFParams)
+	if err != nil {
+		t.Fatalf("security.Encrypt: want: nil, have: %v", err)
+	}
+	if err := Decrypt(fresh, key, &v); err != ErrWrongKey {
+		t.Fatalf("security.Decrypt: stale vault key: want: %v, have: %v", ErrWrongKey, err)
+	}
+
+	legacy := encryptLegacy(t, "group-key", testVault{Name: "sherlock"})
+	if _, err := DeriveVaultKey(legacy, "group-key"); err != ErrNoVaultKey {
+		t.Fatalf("security.DeriveVaultKey: legacy vault: want: %v, have: %v", ErrNoVaultKey, err)
+	}
+	if err := Decrypt(legacy, key, &v); err != ErrWrongKey {
+		t.Fatalf("security.Decrypt: legacy vault: want: %v, have: %v", ErrWrongKey, err)
+	}
+}
@@ -0,0 +1,266 @@
+package security
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+
+	passwordvalidator "github.com/wagslane/go-password-validator"
+)
+
+const (
+	// DefaultMinEntropy is the entropy in bits a password needs
+	// to be secure if no other minimum is set
+	DefaultMinEntropy = 60
+
+	// minPasswordLength is the length below which
+	// a password is reported as too short
+	minPasswordLength = 12
+	// minPatternLength is the shortest dictionary word or
+	// keyboard walk detected in a password
+	minPatternLength = 4
+)
+
+// Weakness is a flaw detected in a password
+type Weakness string
+
+const (
+	WeaknessTooShort       Weakness = "too short"
+	WeaknessMissingClasses Weakness = "missing character classes"
+	WeaknessRepeated       Weakness = "repeated characters or patterns"
+	WeaknessDictionary     Weakness = "dictionary words"
+	WeaknessKeyboardWalk   Weakness = "keyboard walks or sequences"
+)
+
+// keyboardWalks are rows of common keyboard layouts and sequences
+// which are easy to type and therefore common in passwords
+var keyboardWalks = []string{
+	"qwertyuiop", "qwertzuiop", "azertyuiop",
+	"asdfghjkl", "zxcvbnm", "yxcvbnm",
+	"1234567890", "abcdefghijklmnopqrstuvwxyz",
+}
+
+// commonWords are words found in most lists of leaked passwords
+var commonWords = []string{
+	"password", "passwort", "pass", "admin", "root", "login", "welcome",
+	"letmein", "secret", "master", "monkey", "dragon", "shadow", "sunshine",
+	"princess", "football", "baseball", "soccer", "hockey", "batman",
+	"superman", "iloveyou", "trustno1", "hello", "freedom", "whatever",
+	"qazwsx", "michael", "jordan", "summer", "winter", "spring", "autumn",
+	"love", "test", "guest", "user", "changeme", "default", "sherlock",
+	"holmes", "starwars", "pokemon", "computer", "internet", "google",
+	"access", "flower", "cookie", "cheese", "charlie", "killer", "ninja",
+	"mustang", "jennifer", "hunter", "ranger", "buster", "thomas", "tigger",
+}
+
+var (
+	dictOnce sync.Once
+	dict     map[string]float64
+	// maxWordLength is the length of the longest dictionary word
+	maxWordLength int
+)
+
+// dictionary maps the common words and the words of the EFF wordlist
+// to the entropy in bits of picking the word from its list
+func dictionary() map[string]float64 {
+	dictOnce.Do(func() {
+		dict = make(map[string]float64)
+		add := func(words []string) {
+			bits := math.Log2(float64(len(words)))
+			for _, w := range words {
+				if len(w) < minPatternLength {
+					continue
+				}
+				if prev, ok := dict[w]; !ok || bits < prev {
+					dict[w] = bits
+				}
+				if len(w) > maxWordLength {
+					maxWordLength = len(w)
+				}
+			}
+		}
+		add(wordlist())
+		add(commonWords)
+	})
+	return dict
+}
+
+// Strength is the result of measuring a password
+type Strength struct {
+	// Entropy is the estimated entropy in bits
+	Entropy     float64
+	Weaknesses  []Weakness
+	Suggestions []string
+}
+
+// Secure reports whether the password has at least min bits of entropy
+func (s Strength) Secure(min float64) bool {
+	return s.Entropy >= min
+}
+
+func (s *Strength) add(w Weakness, suggestion string) {
+	s.Weaknesses = append(s.Weaknesses, w)
+	s.Suggestions = append(s.Suggestions, suggestion)
+}
+
+// MeasureStrength estimates the entropy of the password and reports its
+// weaknesses with suggestions how to fix them. Dictionary words only count
+// as much as picking the word from the dictionary
+func MeasureStrength(password string) Strength {
+	s := Strength{Entropy: passwordvalidator.GetEntropy(password)}
+	lower := strings.ToLower(password)
+
+	if len([]rune(password)) < minPasswordLength {
+		s.add(WeaknessTooShort, fmt.Sprintf("use at least %d characters", minPasswordLength))
+	}
+	if missing := missingClasses(password); len(missing) > 0 {
+		s.add(WeaknessMissingClasses, "add "+strings.Join(missing, ", "))
+	}
+	if hasRepetition(lower) {
+		s.add(WeaknessRepeated, "avoid repeated characters or patterns like aaa or abab")
+		s.Entropy = math.Min(s.Entropy, passwordvalidator.GetEntropy(collapseRepetition(password)))
+	}
+	if hasKeyboardWalk(lower) {
+		s.add(WeaknessKeyboardWalk, "avoid keyboard walks or sequences like qwerty or 1234")
+	}
+	if bits, found := dictionaryEntropy(lower); found {
+		s.add(WeaknessDictionary, "avoid common words or use a passphrase of at least 6 random words")
+		s.Entropy = math.Min(s.Entropy, bits)
+	}
+	return s
+}
+
+// missingClasses returns the names of the character
+// classes the password does not use
+func missingClasses(password string) []string {
+	var lower, upper, digits, symbols bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digits = true
+		default:
+			symbols = true
+		}
+	}
+	var missing []string
+	for _, class := range []struct {
+		name string
+		used bool
+	}{
+		{"lower case letters", lower},
+		{"upper case letters", upper},
+		{"digits", digits},
+		{"symbols", symbols},
+	} {
+		if !class.used {
+			missing = append(missing, class.name)
+		}
+	}
+	return missing
+}
+
+// hasRepetition detects a character repeated three times or
+// a longer pattern repeated twice in a row. A pattern of size n
+// repeats when n (2 for single characters) consecutive characters
+// equal the character n positions ahead, so every size is a single
+// scan without comparing substrings
+func hasRepetition(password string) bool {
+	for size := 1; size <= len(password)/2; size++ {
+		want := size
+		if size == 1 {
+			want = 2
+		}
+		var run int
+		for i := 0; i+size < len(password); i++ {
+			if password[i] != password[i+size] {
+				run = 0
+				continue
+			}
+			if run++; run >= want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collapseRepetition drops the repetitions of patterns of at least two
+// characters so a repeated pattern only counts once. Repeated characters
+// are already discounted by passwordvalidator.GetEntropy
+func collapseRepetition(password string) string {
+	for size := len(password) / 2; size >= 2; size-- {
+		for i := 0; i+size*2 <= len(password); {
+			if password[i:i+size] == password[i+size:i+size*2] {
+				password = password[:i+size] + password[i+size*2:]
+				continue
+			}
+			i++
+		}
+	}
+	return password
+}
+
+// hasKeyboardWalk detects a keyboard walk or sequence
+// of at least minPatternLength characters in either direction
+func hasKeyboardWalk(password string) bool {
+	for _, walk := range keyboardWalks {
+		for _, seq := range []string{walk, reverse(walk)} {
+			for i := 0; i+minPatternLength <= len(seq); i++ {
+				if strings.Contains(password, seq[i:i+minPatternLength]) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// dictionaryEntropy splits the password in common words, words of the EFF
+// wordlist and the remaining characters. Each word adds the bits of picking
+// it from its list, the remaining characters are measured as usual
+func dictionaryEntropy(password string) (float64, bool) {
+	words := dictionary()
+	var rest strings.Builder
+	var bits float64
+	var found bool
+	for i := 0; i < len(password); {
+		n := longestWord(words, password[i:])
+		if n == 0 {
+			rest.WriteByte(password[i])
+			i++
+			continue
+		}
+		bits += words[password[i:i+n]]
+		found = true
+		i += n
+	}
+	return bits + passwordvalidator.GetEntropy(rest.String()), found
+}
+
+// longestWord returns the length of the longest dictionary
+// word the password starts with or 0
+func longestWord(words map[string]float64, password string) int {
+	for n := maxWordLength; n >= minPatternLength; n-- {
+		if n > len(password) {
+			continue
+		}
+		if _, ok := words[password[:n]]; ok {
+			return n
+		}
+	}
+	return 0
+}
+
+func reverse(s string) string {
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
@@ -0,0 +1,164 @@
+package security
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+)
+
+// vaultMagic marks a file as a sherlock vault. Every vault written
+// by sherlock starts with these bytes followed by the header
+var vaultMagic = []byte("SHLK")
+
+const (
+	// formatVersion is the current on-disk format of a vault
+	formatVersion uint8 = 1
+
+	// cipherAESGCM identifies AES-256 in Galois/Counter Mode
+	cipherAESGCM uint8 = 1
+
+	// kdfSHA512 derives the vault key with a single SHA-512 pass
+	// over the group key. It is only supported to read vaults
+	kdfSHA512 uint8 = 1
+	// kdfArgon2id derives the vault key with Argon2id using a
+	// per-vault salt and the cost parameters stored in the header
+	kdfArgon2id uint8 = 2
+
+	// keyCheckSize is the length of the key check value stored in
+	// the header which allows to tell a wrong key from a damaged vault
+	keyCheckSize = 16
+	// encKeySize is the length of the AES-256 key
+	encKeySize = 32
+)
+
+// header describes how a vault has been encrypted.
+//
+// on-disk layout (all integers big-endian):
+//
+//	magic(4) | version(1) | cipher(1) | kdf(1) | len(kdfParams)(2) | kdfParams
+//	| keyCheck(16) | len(nonce)(1) | nonce | ciphertext
+//
+// the encoded header is used as additional data of the AEAD so any change
+// to it is detected when the vault is opened.
+type header struct {
+	Version   uint8
+	Cipher    uint8
+	KDF       uint8
+	KDFParams []byte
+	KeyCheck  []byte
+	Nonce     []byte
+}
+
+// marshal encodes the header in its on-disk format
+func (h header) marshal() []byte {
+	var buf bytes.Buffer
+	buf.Write(vaultMagic)
+	buf.WriteByte(h.Version)
+	buf.WriteByte(h.Cipher)
+	buf.WriteByte(h.KDF)
+	_ = binary.Write(&buf, binary.BigEndian, uint16(len(h.KDFParams)))
+	buf.Write(h.KDFParams)
+	buf.Write(h.KeyCheck)
+	buf.WriteByte(uint8(len(h.Nonce)))
+	buf.Write(h.Nonce)
+	return buf.Bytes()
+}
+
+// parseHeader decodes the header of a vault and returns it together with
+// its raw bytes and the remaining ciphertext
+func parseHeader(b []byte) (*header, []byte, []byte, error) {
+	r := bytes.NewReader(b)
+
+	magic := make([]byte, len(vaultMagic))
+	if _, err := r.Read(magic); err != nil || !bytes.Equal(magic, vaultMagic) {
+		return nil, nil, nil, ErrCorruptedVault
+	}
+	var h header
+	for _, field := range []*uint8{&h.Version, &h.Cipher, &h.KDF} {
+		v, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, nil, ErrCorruptedVault
+		}
+		*field = v
+	}
+	if h.Version != formatVersion {
+		return nil, nil, nil, ErrUnsupportedVault
+	}
+
+	var paramsLen uint16
+	if err := binary.Read(r, binary.BigEndian, &paramsLen); err != nil {
+		return nil, nil, nil, ErrCorruptedVault
+	}
+	h.KDFParams = make([]byte, paramsLen)
+	if err := readFull(r, h.KDFParams); err != nil {
+		return nil, nil, nil, err
+	}
+	h.KeyCheck = make([]byte, keyCheckSize)
+	if err := readFull(r, h.KeyCheck); err != nil {
+		return nil, nil, nil, err
+	}
+	nonceLen, err := r.ReadByte()
+	if err != nil {
+		return nil, nil, nil, ErrCorruptedVault
+	}
+	h.Nonce = make([]byte, nonceLen)
+	if err := readFull(r, h.Nonce); err != nil {
+		return nil, nil, nil, err
+	}
+
+	headerLen := len(b) - r.Len()
+	return &h, b[:headerLen], b[headerLen:], nil
+}
+
+// readFull fills p from r and maps a short read to ErrCorruptedVault
+func readFull(r *bytes.Reader, p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if n, err := r.Read(p); err != nil || n != len(p) {
+		return ErrCorruptedVault
+	}
+	return nil
+}
+
+// deriveKeys turns the group key into the AES key and the key check
+// value according to the KDF of the header
+func deriveKeys(h *header, key string) ([]byte, []byte, error) {
+	material, err := keyMaterial(h, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aesKey, keyCheck := splitKeyMaterial(material)
+	return aesKey, keyCheck, nil
+}
+
+// splitKeyMaterial returns the AES key and the key check value of the material
+func splitKeyMaterial(material []byte) ([]byte, []byte) {
+	check := sha256.Sum256(material[encKeySize:])
+	return material[:encKeySize], check[:keyCheckSize]
+}
+
+// keyMaterial derives the key material from the group key according to
+// the KDF of the header. A vault key (see DeriveVaultKey) holds the material
+// itself and is only accepted for the salt and parameters it was derived with
+func keyMaterial(h *header, key string) ([]byte, error) {
+	if vk, ok := parseVaultKey(key); ok {
+		if h.KDF != kdfArgon2id || !bytes.Equal(vk.kdfParams, h.KDFParams) {
+			return nil, ErrWrongKey
+		}
+		return vk.material, nil
+	}
+	switch h.KDF {
+	case kdfSHA512:
+		sum := sha512.Sum512([]byte(key))
+		return sum[:], nil
+	case kdfArgon2id:
+		p, salt, err := parseArgon2Params(h.KDFParams)
+		if err != nil {
+			return nil, err
+		}
+		return argon2Key(key, salt, p), nil
+	}
+	return nil, ErrUnsupportedVault
+}
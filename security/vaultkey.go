@@ -0,0 +1,63 @@
+package security
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+)
+
+// vaultKeyPrefix tells a vault key apart from a group key
+const vaultKeyPrefix = "sherlock-vault-key:"
+
+// vaultKey is the key material of a vault derived from its group key
+// together with the salt and parameters it was derived with
+type vaultKey struct {
+	kdfParams []byte
+	material  []byte
+}
+
+// DeriveVaultKey derives the key of the vault from the group key. The vault
+// key opens the vault (see Decrypt) and encrypts new versions of it with the
+// same salt (see Encrypt) without another key derivation and without the
+// group key. It no longer opens the vault once the vault is encrypted with
+// the group key again.
+//
+// It returns ErrWrongKey if the group key does not match the vault and
+// ErrNoVaultKey for vaults not using Argon2id
+func DeriveVaultKey(b []byte, key string) (string, error) {
+	if IsLegacy(b) {
+		return "", ErrNoVaultKey
+	}
+	h, _, _, err := parseHeader(b)
+	if err != nil {
+		return "", err
+	}
+	if h.KDF != kdfArgon2id {
+		return "", ErrNoVaultKey
+	}
+	material, err := keyMaterial(h, key)
+	if err != nil {
+		return "", err
+	}
+	if _, keyCheck := splitKeyMaterial(material); subtle.ConstantTimeCompare(keyCheck, h.KeyCheck) != 1 {
+		return "", ErrWrongKey
+	}
+	return vaultKey{kdfParams: h.KDFParams, material: material}.String(), nil
+}
+
+func (vk vaultKey) String() string {
+	return vaultKeyPrefix + base64.StdEncoding.EncodeToString(append(append([]byte{}, vk.kdfParams...), vk.material...))
+}
+
+// parseVaultKey decodes a key returned by DeriveVaultKey. It
+// reports false if the key is a group key
+func parseVaultKey(key string) (vaultKey, bool) {
+	if !strings.HasPrefix(key, vaultKeyPrefix) {
+		return vaultKey{}, false
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(key, vaultKeyPrefix))
+	if err != nil || len(b) != argon2ParamsSize+2*encKeySize {
+		return vaultKey{}, false
+	}
+	return vaultKey{kdfParams: b[:argon2ParamsSize], material: b[argon2ParamsSize:]}, true
+}
@@ -71,10 +71,16 @@ func ReadPassword(format string, a ...interface{}) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	fmt.Print("\n")
+	fmt.Fprint(os.Stderr, "\n")
 	return string(b), nil
 }
 
+// IsTTY reports whether stdout is a terminal. If not, output
+// should be plain without colors and emojis
+func IsTTY() bool {
+	return terminal.IsTerminal(int(os.Stdout.Fd()))
+}
+
 func ReadLine(format string, a ...interface{}) (string, error) {
 	r := bufio.NewReader(os.Stdin)
 	prettyNoNewLine(color.FgHiBlue, emoji.Pencil, format, a...)
@@ -99,9 +105,10 @@ func pretty(c color.Attribute, e emoji.Emoji, f string, a ...interface{}) {
 }
 
 // prettyNoNewLine combines the colors and emojis and outputs a formatted string to the
-// cli. does not add a \n to the format string
+// cli. does not add a \n to the format string. It is used for prompts which are written
+// to stderr so they do not end up in the output if stdout is redirected
 func prettyNoNewLine(c color.Attribute, e emoji.Emoji, f string, a ...interface{}) {
-	_, _ = color.New(c).Printf(fmt.Sprintf("%v %s", e, f), a...)
+	_, _ = color.New(c).Fprintf(os.Stderr, fmt.Sprintf("%v %s", e, f), a...)
 }
 
 var bgC = []int{
@@ -128,7 +135,7 @@ func ToTable(header []string, rows [][]string, opts ...func(*tablewriter.Table))
 func buildHeader(t *tablewriter.Table, h []string) {
 	colors := make([]tablewriter.Colors, len(h))
 	for i := 0; i < len(h); i++ {
-		colors[i] = tablewriter.Colors{tablewriter.Bold, bgC[i%len(h)]}
+		colors[i] = tablewriter.Colors{tablewriter.Bold, bgC[i%len(bgC)]}
 	}
 	t.SetHeaderColor(colors...)
 }